@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/prattmic/lesser/lineio"
+)
+
+// Buffer is a file open in Lesser, along with its display state.
+// Fields other than name and source must only be accessed with Lesser.mu
+// held.
+type Buffer struct {
+	// name is the name of the file.
+	name string
+
+	// path is the absolute path of the file, or "" if it has none, such
+	// as standard input. Marks are saved under it.
+	path string
+
+	// source is the underlying data being displayed.
+	// Must only be accessed by the main goroutine.
+	source Source
+
+	// src reads lines from source.
+	// It is replaced if the source is truncated or replaced.
+	src *lineio.LineReader
+
+	// line is the line number of the first line of the display.
+	line int64
+
+	// row is the first displayed screen row of line, if line wraps
+	// onto several rows.
+	row int
+
+	// column is the first displayed screen column, when chopping long
+	// lines.
+	column int
+
+	// rows caches the number of screen rows of the last long line
+	// counted by rowCount.
+	rows rowCache
+
+	// searchResults are the results for the current search.
+	// They should be highlighted.
+	searchResults *searchResults
+
+	// filter limits the lines displayed, or is nil if every line is
+	// displayed.
+	filter *filter
+
+	// highlights are the results for each of Lesser.highlights.
+	highlights []*searchResults
+
+	// marks are the lines marked in the file, by mark name.
+	marks map[rune]int64
+
+	// jumps are the top lines before recent large jumps, from oldest to
+	// newest.
+	jumps []int64
+
+	// jump is the index in jumps of the top line, while moving through
+	// the jump list, or len(jumps) otherwise.
+	jump int
+
+	// streaming is true if the source may still be receiving data.
+	streaming bool
+
+	// indexed is true if src had finished indexing the source at the
+	// last update.
+	indexed bool
+
+	// sourceErr is the last error returned by the source, if any.
+	sourceErr error
+}
+
+// reset discards everything known about the source, after its existing
+// contents have changed.
+func (b *Buffer) reset() {
+	// Cached line offsets and search results refer to the old contents.
+	b.src = lineio.NewLineReader(b.source)
+	b.rows = rowCache{}
+	b.searchResults = b.searchResults.restart()
+	for i, h := range b.highlights {
+		b.highlights[i] = h.restart()
+	}
+	if b.filter != nil {
+		b.filter = &filter{
+			results: b.filter.results.restart(),
+			invert:  b.filter.invert,
+		}
+	}
+	b.line = 1
+	b.row = 0
+}
+
+func NewBuffer(name string, s Source) *Buffer {
+	return &Buffer{
+		name:          name,
+		source:        s,
+		src:           lineio.NewLineReader(s),
+		line:          1,
+		searchResults: NewSearchResults(nil),
+		marks:         make(map[rune]int64),
+	}
+}
@@ -0,0 +1,155 @@
+// Package decompress detects compressed data by its magic bytes, and
+// decompresses it.
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrUnsupported = errors.New("Unsupported compression format.")
+
+// Format is a compression format.
+type Format int
+
+const (
+	// None is uncompressed data.
+	None Format = iota
+	// Gzip is gzip (RFC 1952) compressed data.
+	Gzip
+	// Bzip2 is bzip2 compressed data.
+	Bzip2
+	// Zlib is zlib (RFC 1950) compressed data.
+	Zlib
+	// Xz is xz compressed data.  It is detected, but not supported.
+	Xz
+	// Zstd is Zstandard compressed data.  It is detected, but not
+	// supported.
+	Zstd
+)
+
+func (f Format) String() string {
+	switch f {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	case Zlib:
+		return "zlib"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// HeaderSize is the number of bytes needed by Detect to identify any format.
+const HeaderSize = 6
+
+// magic maps the magic bytes at the start of data to its format.
+var magic = []struct {
+	prefix []byte
+	// next, if not empty, are the bytes that may follow prefix.
+	next   []byte
+	format Format
+}{
+	{prefix: []byte{0x1f, 0x8b}, format: Gzip},
+	// "BZh" is followed by the block size, so text starting with "BZh"
+	// isn't mistaken for bzip2 data.
+	{prefix: []byte("BZh"), next: []byte("123456789"), format: Bzip2},
+	// Zlib headers are a single byte of method, followed by a byte of
+	// flags. Only the common compression levels are detected, to avoid
+	// mistaking text for zlib data.
+	{prefix: []byte{0x78, 0x01}, format: Zlib},
+	{prefix: []byte{0x78, 0x9c}, format: Zlib},
+	{prefix: []byte{0x78, 0xda}, format: Zlib},
+	{prefix: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, format: Xz},
+	{prefix: []byte{0x28, 0xb5, 0x2f, 0xfd}, format: Zstd},
+}
+
+// Detect returns the compression format of data beginning with header.
+// header should contain at least HeaderSize bytes, unless the data is
+// shorter than that.
+func Detect(header []byte) Format {
+	for _, m := range magic {
+		if !bytes.HasPrefix(header, m.prefix) {
+			continue
+		}
+		if len(m.next) > 0 && (len(header) == len(m.prefix) || bytes.IndexByte(m.next, header[len(m.prefix)]) < 0) {
+			continue
+		}
+		return m.format
+	}
+	return None
+}
+
+// NewReader returns a reader that decompresses r, which is compressed in
+// format f.  The caller should call Close when finished with the reader.
+func NewReader(r io.Reader, f Format) (io.ReadCloser, error) {
+	switch f {
+	case None:
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case Zlib:
+		return zlib.NewReader(r)
+	}
+
+	return nil, fmt.Errorf("%v: %w", f, ErrUnsupported)
+}
+
+// autoReader detects the format of its input on the first Read, and
+// decompresses it.
+type autoReader struct {
+	// r is the input.
+	r io.Reader
+
+	// d decompresses r.  It is nil until the first Read.
+	d io.ReadCloser
+
+	// err is the error from creating d, if any.
+	err error
+}
+
+// NewAutoReader returns a reader that decompresses r, if r is compressed
+// in a supported format.  If r is not compressed, it is returned unchanged.
+// The format is not detected until the first Read, so NewAutoReader never
+// blocks waiting for input.
+func NewAutoReader(r io.Reader) io.ReadCloser {
+	return &autoReader{r: r}
+}
+
+func (a *autoReader) Read(p []byte) (int, error) {
+	if a.d == nil && a.err == nil {
+		br := bufio.NewReader(a.r)
+		// Short inputs cannot be compressed, so detect with whatever
+		// is available.  Any error will be returned again by a later
+		// Read.
+		header, _ := br.Peek(HeaderSize)
+		a.d, a.err = NewReader(br, Detect(header))
+	}
+
+	if a.err != nil {
+		return 0, a.err
+	}
+
+	return a.d.Read(p)
+}
+
+func (a *autoReader) Close() error {
+	if a.d == nil {
+		return nil
+	}
+	return a.d.Close()
+}
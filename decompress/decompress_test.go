@@ -0,0 +1,143 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"testing"
+)
+
+const data = "Line 1\nLine 2\n"
+
+func gzipData(t *testing.T) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip failed: %v", err)
+	}
+	return b.Bytes()
+}
+
+func zlibData(t *testing.T) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatalf("zlib failed: %v", err)
+	}
+	return b.Bytes()
+}
+
+// bzip2Data is data compressed by bzip2, which has no compressor in the
+// standard library.
+var bzip2Data = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x21, 0x5d,
+	0xe6, 0xdf, 0x00, 0x00, 0x03, 0x5d, 0x00, 0x00, 0x10, 0x40, 0x00, 0x30,
+	0x00, 0x00, 0x04, 0x02, 0x21, 0x20, 0x00, 0x31, 0x0c, 0x08, 0x12, 0x86,
+	0x46, 0x89, 0x68, 0xc6, 0x43, 0x88, 0x78, 0xbb, 0x92, 0x29, 0xc2, 0x84,
+	0x81, 0x0a, 0xef, 0x36, 0xf8,
+}
+
+func TestDecompress(t *testing.T) {
+	cases := []struct {
+		input  []byte
+		format Format
+	}{
+		{input: []byte(data), format: None},
+		{input: gzipData(t), format: Gzip},
+		{input: zlibData(t), format: Zlib},
+		{input: bzip2Data, format: Bzip2},
+	}
+
+	for _, c := range cases {
+		f := Detect(c.input)
+		if f != c.format {
+			t.Errorf("Detect(%v) got %v want %v", c.format, f, c.format)
+			continue
+		}
+
+		r, err := NewReader(bytes.NewReader(c.input), f)
+		if err != nil {
+			t.Errorf("NewReader(%v) got err %v want nil", f, err)
+			continue
+		}
+
+		out, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("%v: read got err %v want nil", f, err)
+		}
+
+		if string(out) != data {
+			t.Errorf("%v: got '%s' want '%s'", f, out, data)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		header []byte
+		format Format
+	}{
+		{header: []byte{}, format: None},
+		{header: []byte{0x1f}, format: None},
+		{header: []byte("x^ not zlib"), format: None},
+		{header: []byte("BZh9\x31\x41"), format: Bzip2},
+		{header: []byte("BZhello"), format: None},
+		{header: []byte("BZh"), format: None},
+		{header: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, format: Xz},
+		{header: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00}, format: Zstd},
+	}
+
+	for _, c := range cases {
+		if f := Detect(c.header); f != c.format {
+			t.Errorf("Detect(%v) got %v want %v", c.header, f, c.format)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	for _, f := range []Format{Xz, Zstd} {
+		_, err := NewReader(bytes.NewReader(nil), f)
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("NewReader(%v) got err %v want %v", f, err, ErrUnsupported)
+		}
+	}
+}
+
+func TestAutoReader(t *testing.T) {
+	cases := [][]byte{
+		[]byte(data),
+		// Too short to contain a full header.
+		[]byte("x"),
+		gzipData(t),
+		bzip2Data,
+	}
+
+	for _, c := range cases {
+		r := NewAutoReader(bytes.NewReader(c))
+
+		out, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("%v: read got err %v want nil", c, err)
+		}
+
+		want := data
+		if Detect(c) == None {
+			want = string(c)
+		}
+
+		if string(out) != want {
+			t.Errorf("%v: got '%s' want '%s'", c, out, want)
+		}
+	}
+
+	r := NewAutoReader(bytes.NewReader([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00}))
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("zstd: read got err %v want %v", err, ErrUnsupported)
+	}
+}
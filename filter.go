@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+)
+
+// filter limits the lines displayed to those matching a pattern, or those
+// that don't.
+type filter struct {
+	// results are the results of searching the entire file for the
+	// pattern, from the first line. Lines beyond the last line searched
+	// aren't displayed until they have been searched.
+	results *searchResults
+
+	// invert displays the lines that don't match, rather than those that
+	// do.
+	invert bool
+}
+
+// shows returns true if line passes the filter.
+func (f *filter) shows(line int64) bool {
+	_, end, _ := f.results.Searched()
+	if line > end {
+		return false
+	}
+
+	_, ok := f.results.Get(line)
+	return ok != f.invert
+}
+
+// next returns the first line after line that passes the filter, if any.
+func (f *filter) next(line int64) (int64, bool) {
+	_, end, _ := f.results.Searched()
+
+	if !f.invert {
+		r, ok := f.results.Next(line)
+		if !ok || r.line > end {
+			return 0, false
+		}
+		return r.line, true
+	}
+
+	for next := line + 1; next <= end; next++ {
+		if _, ok := f.results.Get(next); !ok {
+			return next, true
+		}
+	}
+	return 0, false
+}
+
+// prev returns the last line before line that passes the filter, if any.
+func (f *filter) prev(line int64) (int64, bool) {
+	_, end, _ := f.results.Searched()
+	if line > end+1 {
+		line = end + 1
+	}
+
+	if !f.invert {
+		r, ok := f.results.Prev(line)
+		if !ok {
+			return 0, false
+		}
+		return r.line, true
+	}
+
+	for prev := line - 1; prev >= 1; prev-- {
+		if _, ok := f.results.Get(prev); !ok {
+			return prev, true
+		}
+	}
+	return 0, false
+}
+
+// shows returns true if line is displayed: it exists, and passes the filter,
+// if there is one.
+// Lesser.mu must be held on call.
+func (b *Buffer) shows(line int64) bool {
+	if line < 1 || !b.src.LineExists(line) {
+		return false
+	}
+
+	return b.filter == nil || b.filter.shows(line)
+}
+
+// nextLine returns the first line displayed after line, if any.
+// Lesser.mu must be held on call.
+func (b *Buffer) nextLine(line int64) (int64, bool) {
+	if b.filter != nil {
+		return b.filter.next(line)
+	}
+
+	if line < 0 {
+		line = 0
+	}
+	if !b.src.LineExists(line + 1) {
+		return 0, false
+	}
+	return line + 1, true
+}
+
+// prevLine returns the last line displayed before line, if any.
+// Lesser.mu must be held on call.
+func (b *Buffer) prevLine(line int64) (int64, bool) {
+	if b.filter != nil {
+		return b.filter.prev(line)
+	}
+
+	if line <= 1 {
+		return 0, false
+	}
+	if !b.src.LineExists(line - 1) {
+		return b.src.LastLine(), true
+	}
+	return line - 1, true
+}
+
+// lastLine returns the last line displayed, if any.
+// Lesser.mu must be held on call.
+func (b *Buffer) lastLine() (int64, bool) {
+	return b.prevLine(math.MaxInt64)
+}
+
+// find is like results.Find, but skips results on lines that aren't
+// displayed.
+// Lesser.mu must be held on call.
+func (b *Buffer) find(results *searchResults, line int64, backward bool) (r searchResult, found, certain, wrapped bool) {
+	for {
+		var w bool
+		r, found, certain, w = results.Find(line, backward)
+		if w {
+			// Wrapping twice means every result is hidden.
+			if wrapped {
+				return searchResult{}, false, certain, true
+			}
+			wrapped = true
+		}
+
+		if !found || b.shows(r.line) {
+			return r, found, certain, wrapped
+		}
+		line = r.line
+	}
+}
+
+// findN is like find, but finds the nth result after line, or before it if
+// backward.  If there are fewer than n results in that direction, nothing is
+// found.
+// Lesser.mu must be held on call.
+func (b *Buffer) findN(results *searchResults, line int64, backward bool, n int) (r searchResult, found, certain, wrapped bool) {
+	var first int64
+	for i := 0; i < n; i++ {
+		var w bool
+		r, found, certain, w = b.find(results, line, backward)
+		wrapped = wrapped || w
+		if !found {
+			return searchResult{}, false, certain, wrapped
+		}
+
+		// Once a wrapping search comes back around to the first
+		// result, skip the whole trips around the file.
+		if i == 0 {
+			first = r.line
+		} else if r.line == first {
+			n = i + 1 + (n-i-1)%i
+		}
+		line = r.line
+	}
+	return r, found, certain, wrapped
+}
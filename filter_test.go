@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prattmic/lesser/lineio"
+)
+
+// testResults returns results for lines, from searching every line from the
+// first through end, and to the end of the file if atEOF.
+func testResults(end int64, atEOF bool, lines ...int64) *searchResults {
+	s := NewSearchResults(nil)
+	for _, line := range lines {
+		s.Add(searchResult{line: line})
+	}
+	s.end = end
+	s.atEOF = atEOF
+	return s
+}
+
+func TestFilterNextPrev(t *testing.T) {
+	matches := []int64{2, 5, 8}
+	searched := testResults(10, true, matches...)
+	partial := testResults(6, false, matches...)
+
+	cases := []struct {
+		name     string
+		f        *filter
+		line     int64
+		backward bool
+		want     int64
+		ok       bool
+	}{
+		// &pattern shows the matching lines.
+		{name: "&", f: &filter{results: searched}, line: 0, want: 2, ok: true},
+		{name: "&", f: &filter{results: searched}, line: 2, want: 5, ok: true},
+		{name: "&", f: &filter{results: searched}, line: 8, ok: false},
+		{name: "&", f: &filter{results: searched}, line: 5, backward: true, want: 2, ok: true},
+		{name: "&", f: &filter{results: searched}, line: 2, backward: true, ok: false},
+		{name: "&", f: &filter{results: searched}, line: math.MaxInt64, backward: true, want: 8, ok: true},
+		// &!pattern shows the lines that don't match.
+		{name: "&!", f: &filter{results: searched, invert: true}, line: 0, want: 1, ok: true},
+		{name: "&!", f: &filter{results: searched, invert: true}, line: 1, want: 3, ok: true},
+		{name: "&!", f: &filter{results: searched, invert: true}, line: 4, want: 6, ok: true},
+		{name: "&!", f: &filter{results: searched, invert: true}, line: 10, ok: false},
+		{name: "&!", f: &filter{results: searched, invert: true}, line: 3, backward: true, want: 1, ok: true},
+		{name: "&!", f: &filter{results: searched, invert: true}, line: 1, backward: true, ok: false},
+		{name: "&!", f: &filter{results: searched, invert: true}, line: math.MaxInt64, backward: true, want: 10, ok: true},
+		// Lines beyond the last searched aren't shown yet.
+		{name: "& partly searched", f: &filter{results: partial}, line: 5, ok: false},
+		{name: "& partly searched", f: &filter{results: partial}, line: math.MaxInt64, backward: true, want: 5, ok: true},
+		{name: "&! partly searched", f: &filter{results: partial, invert: true}, line: 4, want: 6, ok: true},
+		{name: "&! partly searched", f: &filter{results: partial, invert: true}, line: 6, ok: false},
+		{name: "&! partly searched", f: &filter{results: partial, invert: true}, line: math.MaxInt64, backward: true, want: 6, ok: true},
+	}
+
+	for _, c := range cases {
+		method := "next"
+		var line int64
+		var ok bool
+		if c.backward {
+			method = "prev"
+			line, ok = c.f.prev(c.line)
+		} else {
+			line, ok = c.f.next(c.line)
+		}
+		if line != c.want || ok != c.ok {
+			t.Errorf("%s: %s(%d) got %d, %v want %d, %v", c.name, method, c.line, line, ok, c.want, c.ok)
+		}
+	}
+}
+
+// testBuffer returns a buffer of lines lines.
+func testBuffer(lines int) *Buffer {
+	var s strings.Builder
+	for i := 0; i < lines; i++ {
+		s.WriteString("line\n")
+	}
+	return &Buffer{src: lineio.NewLineReader(strings.NewReader(s.String())), line: 1}
+}
+
+func TestBufferFind(t *testing.T) {
+	matches := []int64{3, 6, 9}
+
+	cases := []struct {
+		name     string
+		filter   *filter
+		results  *searchResults
+		wrap     bool
+		line     int64
+		backward bool
+		n        int
+		want     int64
+		found    bool
+		certain  bool
+		wrapped  bool
+	}{
+		{name: "search", line: 0, n: 1, want: 3, found: true, certain: true},
+		{name: "search", line: 3, n: 1, want: 6, found: true, certain: true},
+		{name: "search", line: 0, n: 2, want: 6, found: true, certain: true},
+		{name: "search", line: 9, n: 1, found: false, certain: true},
+		{name: "search", line: 0, n: 4, found: false, certain: true},
+		{name: "search", line: 6, backward: true, n: 1, want: 3, found: true, certain: true},
+		{name: "search", line: math.MaxInt64, backward: true, n: 2, want: 6, found: true, certain: true},
+		{name: "search", line: 3, backward: true, n: 1, found: false, certain: true},
+		// Wrapping searches continue from the other end.
+		{name: "wrap", wrap: true, line: 9, n: 1, want: 3, found: true, certain: true, wrapped: true},
+		{name: "wrap", wrap: true, line: 6, n: 2, want: 3, found: true, certain: true, wrapped: true},
+		{name: "wrap", wrap: true, line: 3, backward: true, n: 1, want: 9, found: true, certain: true, wrapped: true},
+		// Counts larger than the number of matches go around the file
+		// more than once.
+		{name: "wrap", wrap: true, line: 0, n: 4, want: 3, found: true, certain: true, wrapped: true},
+		{name: "wrap", wrap: true, line: 0, n: 100, want: 3, found: true, certain: true, wrapped: true},
+		{name: "wrap", wrap: true, line: 0, n: 101, want: 6, found: true, certain: true, wrapped: true},
+		{name: "wrap", wrap: true, line: 4, n: 5, want: 9, found: true, certain: true, wrapped: true},
+		{name: "wrap", wrap: true, line: 4, backward: true, n: 1000, want: 3, found: true, certain: true, wrapped: true},
+		{name: "wrap &!", filter: &filter{results: testResults(10, true, 6), invert: true}, wrap: true, line: 0, n: 100, want: 9, found: true, certain: true, wrapped: true},
+		// Matches on lines hidden by &!pattern are skipped.
+		{name: "&!", filter: &filter{results: testResults(10, true, 6), invert: true}, line: 3, n: 1, want: 9, found: true, certain: true},
+		{name: "&!", filter: &filter{results: testResults(10, true, 6), invert: true}, line: 0, n: 2, want: 9, found: true, certain: true},
+		{name: "&!", filter: &filter{results: testResults(10, true, 6), invert: true}, line: 9, backward: true, n: 1, want: 3, found: true, certain: true},
+		// As are those on lines not shown by &pattern.
+		{name: "&", filter: &filter{results: testResults(10, true, 3, 9)}, line: 3, n: 1, want: 9, found: true, certain: true},
+		// Every match hidden.
+		{name: "& hiding all", filter: &filter{results: testResults(10, true, 1)}, wrap: true, line: 0, n: 1, found: false, certain: true, wrapped: true},
+		// Lines not yet searched may hold a nearer match.
+		{name: "partly searched", results: testResults(5, false, 3), line: 3, n: 1, found: false, certain: false},
+		{name: "partly searched", results: testResults(5, false, 3), line: 0, n: 1, want: 3, found: true, certain: true},
+		{name: "partly searched", results: testResults(7, false, 3, 6), line: 0, n: 2, want: 6, found: true, certain: true},
+	}
+
+	for _, c := range cases {
+		b := testBuffer(10)
+		b.filter = c.filter
+
+		results := c.results
+		if results == nil {
+			results = testResults(10, true, matches...)
+		}
+		results.opts.wrap = c.wrap
+
+		if c.n == 1 {
+			r, found, certain, wrapped := b.find(results, c.line, c.backward)
+			if r.line != c.want || found != c.found || certain != c.certain || wrapped != c.wrapped {
+				t.Errorf("%s: find(%d, %v) got %d, %v, %v, %v want %d, %v, %v, %v", c.name, c.line, c.backward, r.line, found, certain, wrapped, c.want, c.found, c.certain, c.wrapped)
+			}
+		}
+
+		r, found, certain, wrapped := b.findN(results, c.line, c.backward, c.n)
+		if r.line != c.want || found != c.found || certain != c.certain || wrapped != c.wrapped {
+			t.Errorf("%s: findN(%d, %v, %d) got %d, %v, %v, %v want %d, %v, %v, %v", c.name, c.line, c.backward, c.n, r.line, found, certain, wrapped, c.want, c.found, c.certain, c.wrapped)
+		}
+	}
+}
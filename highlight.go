@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+// highlightColors are the background colors of highlights, in the order they
+// are used.
+var highlightColors = []termbox.Attribute{
+	termbox.ColorYellow,
+	termbox.ColorCyan,
+	termbox.ColorMagenta,
+	termbox.ColorGreen,
+	termbox.ColorRed,
+	termbox.ColorBlue,
+}
+
+// highlightPattern is a pattern highlighted in every file in its own
+// color, independent of the current search.
+type highlightPattern struct {
+	// pattern is the pattern as entered.
+	pattern string
+
+	// opts are the options the pattern was entered with.
+	opts searchOptions
+
+	// color is the background color of matches.
+	color termbox.Attribute
+}
+
+// nextColor returns the first highlight color not used by a highlight, or
+// one used the least if all of them are.
+// mu must be held on call.
+func (l *Lesser) nextColor() termbox.Attribute {
+	used := make(map[termbox.Attribute]int)
+	for _, h := range l.highlights {
+		used[h.color]++
+	}
+
+	best := highlightColors[0]
+	for _, c := range highlightColors {
+		if used[c] < used[best] {
+			best = c
+		}
+	}
+	return best
+}
+
+// toggleHighlight highlights the matches of s with opts in every file, or
+// removes its highlight if it is already highlighted.  If s is empty, every
+// highlight is removed.  If s is not a valid regexp, it tells the user.
+// mu must not be held on call.
+func (l *Lesser) toggleHighlight(s string, opts searchOptions) {
+	if s == "" {
+		l.mu.Lock()
+		for _, b := range l.buffers {
+			for _, results := range b.highlights {
+				results.Cancel()
+			}
+			b.highlights = nil
+		}
+		l.highlights = nil
+		l.mu.Unlock()
+
+		l.message("highlights cleared")
+		return
+	}
+
+	l.mu.Lock()
+	for i, h := range l.highlights {
+		if h.pattern == s && h.opts == opts {
+			l.removeHighlight(i)
+			l.mu.Unlock()
+
+			l.message("removed highlight %d", i+1)
+			return
+		}
+	}
+	mode := l.caseMode
+	l.mu.Unlock()
+
+	reg, literal, ignoreCase, err := compileSearch(s, opts, mode)
+	if err != nil {
+		l.message("%v", err)
+		return
+	}
+
+	l.mu.Lock()
+	l.highlights = append(l.highlights, &highlightPattern{
+		pattern: s,
+		opts:    opts,
+		color:   l.nextColor(),
+	})
+	n := len(l.highlights)
+
+	var started []*searchResults
+	for _, b := range l.buffers {
+		results := NewSearchResults(reg)
+		results.literal = literal
+		results.ignoreCase = ignoreCase
+		b.highlights = append(b.highlights, results)
+		started = append(started, results)
+	}
+	l.mu.Unlock()
+
+	for i, b := range l.buffers {
+		l.startSearch(b, started[i])
+	}
+
+	l.message("highlight %d: %s", n, s)
+}
+
+// removeHighlight removes highlight i from every file.
+// mu must be held on call.
+func (l *Lesser) removeHighlight(i int) {
+	l.highlights = append(l.highlights[:i], l.highlights[i+1:]...)
+
+	for _, b := range l.buffers {
+		b.highlights[i].Cancel()
+		b.highlights = append(b.highlights[:i], b.highlights[i+1:]...)
+	}
+}
+
+// highlightColor returns the background color to display the character at
+// offset c in line with, if any highlight matches it.  Later highlights are
+// displayed on top of earlier ones.
+// mu must be held on call.
+func (l *Lesser) highlightColor(matches []searchResult, c int) (termbox.Attribute, bool) {
+	for i := len(matches) - 1; i >= 0; i-- {
+		if matches[i].matchesChar(c) {
+			return l.highlights[i].color, true
+		}
+	}
+	return 0, false
+}
+
+// highlightMatches returns the matches of each highlight in line.  Lines
+// without a match of a highlight have an empty result.
+// mu must be held on call.
+func (l *Lesser) highlightMatches(b *Buffer, line int64) []searchResult {
+	var matches []searchResult
+	for _, results := range b.highlights {
+		r, _ := results.Get(line)
+		matches = append(matches, r)
+	}
+	return matches
+}
+
+// nextHighlight moves the display to the count-th next displayed match of
+// highlight n, counting from 1, after the top line, or before it if backward.
+// mu must not be held on call.
+func (l *Lesser) nextHighlight(n int, backward bool, count int) {
+	l.mu.Lock()
+	b := l.buf()
+	if n < 1 || n > len(b.highlights) {
+		l.mu.Unlock()
+		l.message("no highlight %d", n)
+		return
+	}
+
+	results := b.highlights[n-1]
+	r, found, certain, wrapped := b.findN(results, b.line, backward, count)
+	if found {
+		l.jumpLine(r.line)
+	}
+	l.mu.Unlock()
+
+	l.searchStatus(results, r.line, found, certain, wrapped)
+}
+
+// highlightProgress describes how far the searches for highlights in b have
+// gotten, or returns "" if none are running.
+// mu must be held on call.
+func (l *Lesser) highlightProgress(b *Buffer) string {
+	for _, results := range b.highlights {
+		if results.Running() {
+			return l.progress(b, results, "highlighting")
+		}
+	}
+	return ""
+}
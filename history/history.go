@@ -0,0 +1,108 @@
+// Package history keeps a list of previously entered text, such as search
+// patterns, optionally persisted in a file shared between sessions.
+package history
+
+import "github.com/prattmic/lesser/state"
+
+// DefaultSize is the default maximum number of entries.
+const DefaultSize = 100
+
+// History is a list of entries, from oldest to newest.  No entry appears
+// twice.
+type History struct {
+	// path is the file the history is saved in, or "" if it is not
+	// saved.
+	path string
+
+	// size is the maximum number of entries.
+	size int
+
+	// entries are the entries, from oldest to newest.
+	entries []string
+}
+
+// New returns an empty history of at most size entries, which is not saved.
+func New(size int) *History {
+	return &History{size: size}
+}
+
+// Load returns the history of at most size entries saved in the file at
+// path.  If the file doesn't exist, the history is empty.  New entries are
+// saved in the file.
+func Load(path string, size int) (*History, error) {
+	h := &History{path: path, size: size}
+
+	entries, err := state.ReadLines(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		h.add(e)
+	}
+
+	return h, nil
+}
+
+// add adds e as the newest entry, removing any older copy, and the oldest
+// entries beyond the maximum.
+func (h *History) add(e string) {
+	if e == "" {
+		return
+	}
+
+	for i, old := range h.entries {
+		if old == e {
+			h.entries = append(h.entries[:i], h.entries[i+1:]...)
+			break
+		}
+	}
+
+	h.entries = append(h.entries, e)
+
+	if over := len(h.entries) - h.size; over > 0 {
+		h.entries = h.entries[over:]
+	}
+}
+
+// Add adds e as the newest entry.  Empty entries are ignored.
+//
+// If the history is saved, it is first merged with the entries other
+// sessions have saved since, and then the file is updated.  The history is
+// updated even if saving it fails.
+func (h *History) Add(e string) error {
+	if h.path == "" {
+		h.add(e)
+		return nil
+	}
+
+	saved, err := state.ReadLines(h.path)
+	if err != nil {
+		h.add(e)
+		return err
+	}
+
+	// Entries in the file are newer than our own, except for e.
+	entries := h.entries
+	h.entries = nil
+	for _, old := range entries {
+		h.add(old)
+	}
+	for _, old := range saved {
+		h.add(old)
+	}
+	h.add(e)
+
+	// The file is replaced atomically, so other sessions never read a
+	// partial history.
+	return state.WriteLines(h.path, h.entries)
+}
+
+// Len returns the number of entries.
+func (h *History) Len() int {
+	return len(h.entries)
+}
+
+// Get returns entry i, where 0 is the oldest.
+func (h *History) Get(i int) string {
+	return h.entries[i]
+}
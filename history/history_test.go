@@ -0,0 +1,99 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// entries returns all of the entries in h.
+func entries(h *History) []string {
+	var e []string
+	for i := 0; i < h.Len(); i++ {
+		e = append(e, h.Get(i))
+	}
+	return e
+}
+
+func TestAdd(t *testing.T) {
+	cases := []struct {
+		add  []string
+		want []string
+	}{
+		{add: nil, want: nil},
+		{add: []string{"a", "b"}, want: []string{"a", "b"}},
+		{add: []string{"a", "", "b"}, want: []string{"a", "b"}},
+		{add: []string{"a", "b", "a"}, want: []string{"b", "a"}},
+		{add: []string{"a", "b", "c", "d"}, want: []string{"b", "c", "d"}},
+		{add: []string{"a", "b", "c", "a", "d"}, want: []string{"c", "a", "d"}},
+	}
+
+	for _, c := range cases {
+		h := New(3)
+		for _, e := range c.add {
+			if err := h.Add(e); err != nil {
+				t.Errorf("Add(%q) got err %v", e, err)
+			}
+		}
+
+		if got := entries(h); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("after adding %q got %q want %q", c.add, got, c.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("TempDir got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lesser", "history")
+
+	// A missing file is empty.
+	h, err := Load(path, 3)
+	if err != nil {
+		t.Fatalf("Load got err %v", err)
+	}
+	if h.Len() != 0 {
+		t.Errorf("got %q from missing file, want none", entries(h))
+	}
+
+	for _, e := range []string{"a", "b"} {
+		if err := h.Add(e); err != nil {
+			t.Errorf("Add(%q) got err %v", e, err)
+		}
+	}
+
+	// Another session adds entries.
+	other, err := Load(path, 3)
+	if err != nil {
+		t.Fatalf("Load got err %v", err)
+	}
+	if got, want := entries(other), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load got %q want %q", got, want)
+	}
+	if err := other.Add("c"); err != nil {
+		t.Errorf("Add(c) got err %v", err)
+	}
+
+	// Adding merges the other session's entries.
+	if err := h.Add("a"); err != nil {
+		t.Errorf("Add(a) got err %v", err)
+	}
+	want := []string{"b", "c", "a"}
+	if got := entries(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("after merge got %q want %q", got, want)
+	}
+
+	h, err = Load(path, 3)
+	if err != nil {
+		t.Fatalf("Load got err %v", err)
+	}
+	if got := entries(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load got %q want %q", got, want)
+	}
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 
+	"github.com/prattmic/lesser/history"
+	"github.com/prattmic/lesser/lineedit"
 	"github.com/prattmic/lesser/lineio"
+	"github.com/prattmic/lesser/marks"
+	"github.com/prattmic/lesser/render"
+	"github.com/prattmic/lesser/search"
 	"github.com/prattmic/lesser/sortedmap"
+	"github.com/prattmic/lesser/timestamp"
 )
 
 type size struct {
@@ -38,18 +52,180 @@ const (
 	// ModeSearchEntry is search entry mode. Key presses are added
 	// to the search string.
 	ModeSearchEntry
+
+	// ModeCommand follows a ':' key press. The next key press selects
+	// a command.
+	ModeCommand
+
+	// ModeOption follows a '-' key press. The next key press selects
+	// an option to toggle.
+	ModeOption
+
+	// ModeFilterEntry is filter entry mode. Key presses are added to
+	// the filter pattern.
+	ModeFilterEntry
+
+	// ModeHighlightEntry is highlight entry mode. Key presses are added
+	// to the highlight pattern.
+	ModeHighlightEntry
+
+	// ModeHighlightJump follows a ']' or '[' key press. The next key
+	// press selects the highlight to move to the next or previous match
+	// of.
+	ModeHighlightJump
+
+	// ModeLineEntry follows a ':' key press and a digit. Key presses
+	// are added to the number of the line to go to.
+	ModeLineEntry
+
+	// ModeTimeEntry follows a ':' key press and a 't' key press. Key
+	// presses are added to the time to go to.
+	ModeTimeEntry
+
+	// ModeMarkSet follows an 'm' key press. The next key press names
+	// the mark to set.
+	ModeMarkSet
+
+	// ModeMarkJump follows a '\'' key press. The next key press names
+	// the mark to go to.
+	ModeMarkJump
+)
+
+// CaseMode is how searches treat uppercase and lowercase letters.
+type CaseMode int
+
+const (
+	// CaseSensitive matches letters exactly.
+	CaseSensitive CaseMode = iota
+
+	// CaseSmart ignores case, unless the pattern contains an uppercase
+	// letter.
+	CaseSmart
+
+	// CaseInsensitive ignores case.
+	CaseInsensitive
 )
 
+// String describes searches in mode m.
+func (m CaseMode) String() string {
+	switch m {
+	case CaseSmart:
+		return "smart-case search"
+	case CaseInsensitive:
+		return "case-insensitive search"
+	default:
+		return "case-sensitive search"
+	}
+}
+
+// ignoreCase returns true if a search for s, a literal string if literal is
+// true, should ignore case.
+func (m CaseMode) ignoreCase(s string, literal bool) bool {
+	switch m {
+	case CaseSmart:
+		return !hasUpper(s, literal)
+	case CaseInsensitive:
+		return true
+	default:
+		return false
+	}
+}
+
+// hasUpper returns true if the search pattern s, a literal string if literal
+// is true, contains an uppercase letter.  As in less, letters in a regexp
+// count anywhere except in escapes like \S and \p{Greek}.
+func hasUpper(s string, literal bool) bool {
+	if literal {
+		return strings.IndexFunc(s, unicode.IsUpper) >= 0
+	}
+
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		switch {
+		case r[i] == '\\' && i+1 < len(r):
+			i++
+			// Unicode classes are named by a letter, or a
+			// longer name in braces.
+			if (r[i] == 'p' || r[i] == 'P') && i+1 < len(r) {
+				i++
+				if r[i] == '{' {
+					for i < len(r) && r[i] != '}' {
+						i++
+					}
+				}
+			}
+		case unicode.IsUpper(r[i]):
+			return true
+		}
+	}
+	return false
+}
+
+// Options configure Lesser.
+type Options struct {
+	// TabStop is the number of spaces per tab.
+	TabStop int
+
+	// Follow starts the display following the end of the file.
+	Follow bool
+
+	// Color displays text colored by SGR escape sequences, rather than
+	// displaying the escape sequences themselves.
+	Color bool
+
+	// Chop truncates long lines at the edge of the screen, rather than
+	// wrapping them onto the following rows.
+	Chop bool
+
+	// Shift is the number of columns to scroll horizontally. If zero, it
+	// is half the screen width.
+	Shift int
+
+	// SearchEscapes searches escape sequences along with the displayed
+	// text when Color is set. Otherwise, escape sequences are ignored by
+	// searches.
+	SearchEscapes bool
+
+	// LineNumbers displays the number of each line before it.
+	LineNumbers bool
+
+	// Prompt is the format of the prompt in the status bar. See
+	// formatPrompt for the escapes it may contain.
+	Prompt string
+
+	// SearchHistory holds previous search patterns. If nil, the history
+	// starts out empty and isn't saved.
+	SearchHistory *history.History
+
+	// WrapSearch continues searches from the other end of the file when
+	// there are no more matches.
+	WrapSearch bool
+
+	// Case is how searches treat uppercase and lowercase letters.
+	Case CaseMode
+
+	// Marks holds the marks saved for files. If nil, marks are not
+	// saved.
+	Marks *marks.Marks
+}
+
+// updateInterval is how often the source is checked for new data.
+const updateInterval = 100 * time.Millisecond
+
 type Lesser struct {
-	// src is the source file being displayed.
-	src lineio.LineReader
+	// buffers are the open files.
+	buffers []*Buffer
 
-	// tabStop is the number of spaces per tab.
-	tabStop int
+	// opts are the options Lesser was created with.
+	opts Options
 
 	// events is used to notify the main goroutine of events.
 	events chan Event
 
+	// messages carries messages for the user from other goroutines to
+	// the main goroutine, which displays them until the next key press.
+	messages chan string
+
 	// mu locks the fields below.
 	mu sync.Mutex
 
@@ -57,26 +233,85 @@ type Lesser struct {
 	// There is a statusbar beneath the display.
 	size size
 
-	// line is the line number of the first line of the display.
-	line int64
+	// current is the index in buffers of the displayed file.
+	current int
 
 	// mode is the viewer mode.
 	mode Mode
 
-	// regexp is the search regexp specified by the user.
+	// input is the text entered at the prompt, in modes that prompt
+	// for text.
 	// Must only be modified by the event goroutine.
-	regexp string
+	input lineedit.Editor
 
-	// searchResults are the results for the current search.
-	// They should be highlighted.
-	searchResults searchResults
+	// inputHistory is the history of text entered at the current
+	// prompt, or nil if it has none.
+	// Must only be accessed by the event goroutine.
+	inputHistory *history.History
+
+	// historyIndex is the index in inputHistory of the entry recalled at
+	// the prompt. If it is inputHistory.Len(), no entry is recalled.
+	historyIndex int
+
+	// historyDraft is the text entered at the prompt before recalling an
+	// entry from inputHistory.
+	historyDraft string
+
+	// origin is the top of the display when the search prompt began.
+	// Incremental search moves the display from there.
+	origin position
+
+	// searchOpts are the options for the search being entered at the
+	// search prompt.
+	searchOpts searchOptions
+
+	// preview are the matches on the screen for an incremental search,
+	// highlighted in place of the buffer's search results, or nil if
+	// there is none.
+	preview *searchResults
+
+	// incsearchCancel cancels the incremental search in progress, if it
+	// is not nil.
+	incsearchCancel context.CancelFunc
+
+	// following is true if the display should stay at the end of the
+	// file as it grows.
+	following bool
+
+	// chop is true if long lines are truncated, rather than wrapped.
+	chop bool
+
+	// lineNumbers is true if line numbers are displayed.
+	lineNumbers bool
+
+	// caseMode is how searches treat uppercase and lowercase letters.
+	caseMode CaseMode
+
+	// highlights are the patterns highlighted in every file. Each
+	// Buffer has the results for each of them, in the same order.
+	highlights []*highlightPattern
+
+	// highlightBackward is true if ModeHighlightJump moves to the
+	// previous match, rather than the next.
+	highlightBackward bool
+
+	// highlightRepeat is the number of matches ModeHighlightJump moves
+	// by.
+	highlightRepeat int
+
+	// count is the number typed before a command, if counted is true.
+	count   int64
+	counted bool
+
+	// notice is a message to display to the user.
+	// It is cleared by the next key press.
+	notice string
 }
 
-// lastLine returns the last line on the display.  It may be beyond the end
-// of the file, if the file is short enough.
+// buf returns the displayed buffer.
 // mu must be held on call.
-func (l *Lesser) lastLine() int64 {
-	return l.line + int64(l.size.y) - 1
+func (l *Lesser) buf() *Buffer {
+	return l.buffers[l.current]
 }
 
 // Scroll describes a scroll action.
@@ -101,310 +336,2053 @@ const (
 	ScrollDownHalfPage
 )
 
+// position is a screen row in the file.
+type position struct {
+	// line is the line number.
+	line int64
+
+	// row is the screen row within line, if it wraps.
+	row int
+}
+
+// renderOptions returns the options for rendering lines.
+func (l *Lesser) renderOptions() render.Options {
+	return render.Options{
+		TabStop: l.opts.TabStop,
+		SGR:     l.opts.Color,
+	}
+}
+
+// gutterWidth returns the number of columns before the text of each line,
+// which are used to display line numbers.  The gutter fits the largest line
+// number that may be displayed, so its width only changes as the display
+// scrolls past a power of ten.
+// mu must be held on call.
+func (l *Lesser) gutterWidth(b *Buffer) int {
+	if !l.lineNumbers {
+		return 0
+	}
+
+	// Every line takes at least one row, so the last line displayed is
+	// no further than the last row.
+	last := b.line
+	for i := 1; i < l.size.y; i++ {
+		next, ok := b.nextLine(last)
+		if !ok {
+			break
+		}
+		last = next
+	}
+
+	// Leave a space between the numbers and the text.
+	width := len(fmt.Sprint(last)) + 1
+
+	// Always leave at least one column for the text.
+	if width >= l.size.x {
+		return 0
+	}
+
+	return width
+}
+
+// width returns the number of columns used to display the text of each line.
+// mu must be held on call.
+func (l *Lesser) width(b *Buffer) int {
+	return l.size.x - l.gutterWidth(b)
+}
+
+// readFull reads line from byte offset off, in a buffer of size bytes, and
+// calls full with the bytes read, and whether the line may continue beyond
+// them, until full returns true or the line ends.  Each column may need
+// several bytes, and escape sequences need bytes but no columns, so the number
+// of bytes needed to fill a number of rows isn't known in advance.  Each read
+// is twice the size of the last.
+func readFull(src *lineio.LineReader, line int64, off, size int, full func(buf []byte, more bool) bool) error {
+	for {
+		buf := make([]byte, size)
+		n, err := src.ReadLineAt(buf, line, int64(off))
+		// EOF just means the line was shorter than the buffer.
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// A nil error means the line may continue beyond buf.
+		if full(buf[:n], err == nil) || err != nil {
+			return nil
+		}
+		size *= 2
+	}
+}
+
+// lineRows returns up to n screen rows displaying line, starting from row
+// first.  It reads only the part of the line displayed in those rows, and
+// enough before them to lay them out from the nearest row checkpoint.  A line
+// that does not exist is displayed as one empty row.
+// mu must be held on call.
+func (l *Lesser) lineRows(b *Buffer, line int64, first, n int) ([][]render.Cell, error) {
+	if l.chop {
+		row, err := l.choppedRow(b, line)
+		return [][]render.Cell{row}, err
+	}
+
+	width := l.width(b)
+	opts := l.renderOptions()
+
+	start, err := l.skipRows(b, line, first, width, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rows begin with the row start is in.
+	skip := first - (start.Count() - 1)
+
+	var rows [][]render.Cell
+	err = readFull(b.src, line, start.Offset, (skip+n)*width*utf8.UTFMax, func(buf []byte, more bool) bool {
+		rows = render.LineRows(buf, start, width, opts, more)
+		// Once another row has begun, the rows before it are full.
+		return len(rows) > skip+n
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if skip >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[skip:]
+	if len(rows) > n {
+		rows = rows[:n]
+	}
+	return rows, nil
+}
+
+// skipRows returns the layout state from which laying out line includes all
+// of row, starting from the nearest cached checkpoint before it.
+// mu must be held on call.
+func (l *Lesser) skipRows(b *Buffer, line int64, row, width int, opts render.Options) (render.Rows, error) {
+	start := b.rows.checkpoint(line, row, width, opts)
+
+	buf := make([]byte, rowCountChunk)
+	for {
+		n, err := b.src.ReadLineAt(buf, line, int64(start.Offset))
+		if err != nil && err != io.EOF {
+			return start, err
+		}
+
+		// A nil error means the line may continue beyond buf.
+		next, ok := render.SkipRows(buf[:n], start, row, width, opts, err == nil)
+		if ok || err != nil {
+			return next, nil
+		}
+		if next.Offset == start.Offset {
+			// An escape sequence continues beyond buf.
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		start = next
+	}
+}
+
+// choppedRow returns the screen row displaying line when chopping long
+// lines, starting from the horizontal scroll column.
+// mu must be held on call.
+func (l *Lesser) choppedRow(b *Buffer, line int64) ([]render.Cell, error) {
+	opts := l.renderOptions()
+
+	// Find the first displayed character. Every column takes at least
+	// one byte, so reading one byte per column never reads beyond it.
+	var state render.State
+	var extra int
+	for state.Column < b.column {
+		buf := make([]byte, b.column-state.Column+extra)
+		n, err := b.src.ReadLineAt(buf, line, int64(state.Offset))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		next := render.Skip(buf[:n], state, b.column, opts, err == nil)
+		if next == state {
+			// The line ends before the column.
+			if err != nil {
+				break
+			}
+			// An escape or UTF-8 sequence continues beyond buf.
+			extra = 2*extra + utf8.UTFMax
+			continue
+		}
+
+		state = next
+		extra = 0
+	}
+
+	// If the first character straddles the column, such as a wide
+	// character or tab, display blanks in its place.
+	var blanks []render.Cell
+	for i := b.column; i < state.Column; i++ {
+		blanks = append(blanks, render.Cell{Ch: ' ', Width: 1, Offset: -1})
+	}
+
+	width := l.width(b)
+	var row []render.Cell
+	err := readFull(b.src, line, state.Offset, width*utf8.UTFMax, func(buf []byte, more bool) bool {
+		cells := append(blanks, render.LineFrom(buf, state, opts, more)...)
+		rows := render.Wrap(cells, width)
+		row = rows[0]
+		return len(rows) > 1
+	})
+	return row, err
+}
+
+// shift returns the number of columns to scroll horizontally.
+// mu must be held on call.
+func (l *Lesser) shift() int {
+	if l.opts.Shift > 0 {
+		return l.opts.Shift
+	}
+
+	if l.size.x < 2 {
+		return 1
+	}
+
+	return l.size.x / 2
+}
+
+// scrollColumns scrolls the display horizontally by n columns, right if n is
+// positive and left if n is negative.  Scrolling is only possible when
+// chopping long lines.
+func (l *Lesser) scrollColumns(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.chop {
+		return
+	}
+
+	b := l.buf()
+	b.column += n
+	if b.column < 0 {
+		b.column = 0
+	}
+}
+
+// rowCountChunk is the number of bytes of a line counted into rows at a
+// time.
+const rowCountChunk = 64 << 10
+
+// rowCache is the progress of counting the rows of a long line, laid out with
+// the given width and options.
+type rowCache struct {
+	line  int64
+	width int
+	opts  render.Options
+
+	// checkpoints are the layout states after each chunk of the line
+	// counted, from which counting or layout can continue.
+	checkpoints []render.Rows
+}
+
+// matches returns true if c holds the rows of line laid out with width and
+// opts.
+func (c *rowCache) matches(line int64, width int, opts render.Options) bool {
+	return c.line == line && c.width == width && c.opts == opts
+}
+
+// checkpoint returns the last checkpoint of line before row, or the start of
+// the line if there is none.
+func (c *rowCache) checkpoint(line int64, row, width int, opts render.Options) render.Rows {
+	if !c.matches(line, width, opts) {
+		return render.Rows{}
+	}
+
+	i := sort.Search(len(c.checkpoints), func(i int) bool {
+		return c.checkpoints[i].Count()-1 >= row
+	})
+	if i == 0 {
+		return render.Rows{}
+	}
+	return c.checkpoints[i-1]
+}
+
+// rowCount returns the number of screen rows occupied by line, or 0 if it
+// is not displayed.  The line is read in chunks, so long lines don't need to
+// fit in memory.  Checkpoints for the last long line counted are cached, so
+// scrolling through it doesn't read it all again for every row, and only the
+// data added since is read if it grows.
+// mu must be held on call.
+func (l *Lesser) rowCount(b *Buffer, line int64) int {
+	if !b.shows(line) {
+		return 0
+	}
+	if l.chop {
+		return 1
+	}
+
+	width := l.width(b)
+	opts := l.renderOptions()
+
+	c := b.rows
+	if !c.matches(line, width, opts) {
+		c = rowCache{line: line, width: width, opts: opts}
+	}
+	var rows render.Rows
+	if n := len(c.checkpoints); n > 0 {
+		rows = c.checkpoints[n-1]
+	}
+
+	buf := make([]byte, rowCountChunk)
+	for {
+		n, err := b.src.ReadLineAt(buf, line, int64(rows.Offset))
+		if err != nil && err != io.EOF {
+			return rows.Count()
+		}
+
+		// The line may still grow, so checkpoints stop before any
+		// sequence incomplete at the end of buf.
+		next := render.CountRows(buf[:n], rows, width, opts, true)
+		if err != nil {
+			if len(c.checkpoints) > 0 {
+				b.rows = c
+			}
+			// The incomplete sequences are displayed as they are.
+			return render.CountRows(buf[next.Offset-rows.Offset:n], next, width, opts, false).Count()
+		}
+		if next.Offset == rows.Offset {
+			// An escape sequence continues beyond buf.
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		rows = next
+		c.checkpoints = append(c.checkpoints, rows)
+	}
+}
+
+// forward moves p forward by up to n screen rows, stopping at the last row
+// in the file.  It returns the new position and the number of rows moved.
+// mu must be held on call.
+func (l *Lesser) forward(b *Buffer, p position, n int) (position, int) {
+	rows := l.rowCount(b, p.line)
+
+	var moved int
+	for moved < n {
+		if p.row+1 < rows {
+			p.row++
+		} else {
+			next, ok := b.nextLine(p.line)
+			if !ok {
+				break
+			}
+			p = position{line: next}
+			rows = l.rowCount(b, next)
+		}
+		moved++
+	}
+
+	return p, moved
+}
+
+// backward moves p backward by up to n screen rows, stopping at the first
+// row in the file.  It returns the new position and the number of rows
+// moved.
+// mu must be held on call.
+func (l *Lesser) backward(b *Buffer, p position, n int) (position, int) {
+	var moved int
+	for moved < n {
+		if p.row > 0 {
+			p.row--
+		} else if prev, ok := b.prevLine(p.line); ok {
+			p = position{line: prev, row: l.rowCount(b, prev) - 1}
+		} else {
+			break
+		}
+		moved++
+	}
+
+	return p, moved
+}
+
+// setTop displays p at the top of the screen, unless that would leave part
+// of the screen empty at the end of the file, in which case the last screen
+// full of the file is displayed.
+// mu must be held on call.
+func (l *Lesser) setTop(p position) {
+	b := l.buf()
+
+	if p.line < 1 {
+		p = position{line: 1}
+	}
+	// A line that isn't displayed is replaced by the next one that is,
+	// or the last one if there are none after it.
+	if !b.shows(p.line) {
+		if next, ok := b.nextLine(p.line); ok {
+			p = position{line: next}
+		} else if prev, ok := b.prevLine(p.line); ok {
+			p = position{line: prev}
+		}
+	}
+	if rows := l.rowCount(b, p.line); p.row >= rows {
+		p.row = rows - 1
+	}
+	if p.row < 0 {
+		p.row = 0
+	}
+
+	bottom, moved := l.forward(b, p, l.size.y-1)
+	if moved < l.size.y-1 {
+		p, _ = l.backward(b, bottom, l.size.y-1)
+	}
+
+	b.line = p.line
+	b.row = p.row
+}
+
 // scrollLine tries to scroll the display to the given line,
 // but will not scroll beyond the first or last lines in the file.
 // l.mu must be held when calling scrollLine.
 func (l *Lesser) scrollLine(dest int64) {
-	var delta int64
-	if dest > l.line {
-		delta = 1
-	} else {
-		delta = -1
+	l.setTop(position{line: dest})
+}
+
+// scrollRows scrolls the display by n screen rows, down if n is positive
+// and up if n is negative, without going past the beginning or end of the
+// file.
+// l.mu must be held when calling scrollRows.
+func (l *Lesser) scrollRows(n int) {
+	b := l.buf()
+	top := position{line: b.line, row: b.row}
+
+	if n < 0 {
+		top, _ = l.backward(b, top, -n)
+		l.setTop(top)
+		return
+	}
+
+	// Move the top and bottom of the screen together, until the bottom
+	// reaches the end of the file.
+	bottom, _ := l.forward(b, top, l.size.y-1)
+	for i := 0; i < n; i++ {
+		var moved int
+		bottom, moved = l.forward(b, bottom, 1)
+		if moved == 0 {
+			break
+		}
+		top, _ = l.forward(b, top, 1)
 	}
 
-	for l.line != dest && l.line+delta > 0 && l.src.LineExists(l.lastLine()+delta) {
-		l.line += delta
+	b.line = top.line
+	b.row = top.row
+}
+
+// scrollBottom displays the last screen full of the file.
+// l.mu must be held when calling scrollBottom.
+func (l *Lesser) scrollBottom() {
+	b := l.buf()
+	if last, ok := b.lastLine(); ok {
+		l.setTop(position{line: last, row: l.rowCount(b, last) - 1})
 	}
 }
 
-// scroll moves the display based on the passed scroll action, without
-// going past the beginning or end of the file.
-func (l *Lesser) scroll(s Scroll) {
+// scroll moves the display based on the passed scroll action, repeated n
+// times, without going past the beginning or end of the file.
+func (l *Lesser) scroll(s Scroll, n int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	var dest int64
 	switch s {
 	case ScrollTop:
-		dest = 1
+		l.setTop(position{line: 1})
 	case ScrollBottom:
-		// Just try to go to int64 max.
-		dest = 0x7fffffffffffffff
+		l.scrollBottom()
 	case ScrollUp:
-		dest = l.line - 1
+		l.scrollRows(-n)
 	case ScrollDown:
-		dest = l.line + 1
+		l.scrollRows(n)
 	case ScrollUpPage:
-		dest = l.line - int64(l.size.y)
+		l.scrollRows(-n * l.size.y)
 	case ScrollDownPage:
-		dest = l.line + int64(l.size.y)
+		l.scrollRows(n * l.size.y)
 	case ScrollUpHalfPage:
-		dest = l.line - int64(l.size.y)/2
+		l.scrollRows(-n * (l.size.y / 2))
 	case ScrollDownHalfPage:
-		dest = l.line + int64(l.size.y)/2
+		l.scrollRows(n * (l.size.y / 2))
 	}
+}
 
-	l.scrollLine(dest)
+// maxRepeat is the largest number of times a command is repeated, however
+// large its count.  Larger counts would overflow the number of rows to
+// scroll.
+const maxRepeat = 1 << 24
+
+// repeat returns the number of times to repeat a command typed with count,
+// if counted is true.  Commands without a count are run once.
+func repeat(count int64, counted bool) int {
+	switch {
+	case !counted || count < 1:
+		return 1
+	case count > maxRepeat:
+		return maxRepeat
+	default:
+		return int(count)
+	}
 }
 
-func (l *Lesser) handleEvent(e termbox.Event) {
+// gotoLine displays line at the top of the screen.
+func (l *Lesser) gotoLine(line int64) {
 	l.mu.Lock()
-	mode := l.mode
+	defer l.mu.Unlock()
+
+	l.jumpLine(line)
+}
+
+// gotoEnd displays the last screen full of the file.
+func (l *Lesser) gotoEnd() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf().pushJump()
+	l.scrollBottom()
+}
+
+// gotoOffset displays the line containing byte offset at the top of the
+// screen, or the last line if offset is beyond the end of the file.
+func (l *Lesser) gotoOffset(offset int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// At EOF, line is the last line.
+	line, _ := l.buf().src.LineAt(offset)
+	l.jumpLine(line)
+}
+
+// gotoPercent displays the line percent of the way through the file at the
+// top of the screen.  Like less, the percentage is of the bytes in the file,
+// so it doesn't wait for the lines to be counted.
+func (l *Lesser) gotoPercent(percent int64) {
+	if percent > 100 {
+		percent = 100
+	}
+
+	l.mu.Lock()
+	size := l.buf().source.Size()
 	l.mu.Unlock()
 
-	if e.Type != termbox.EventKey {
+	l.gotoOffset(size * percent / 100)
+}
+
+// gotoTime displays the first line with a timestamp at or after the time in
+// s at the top of the screen.  The format of the timestamps is detected from
+// the lines from the top of the screen, and any date or time zone missing
+// from s is taken from the first of them.
+func (l *Lesser) gotoTime(s string) {
+	l.mu.Lock()
+	b := l.buf()
+	src := b.src
+	top := b.line
+	l.mu.Unlock()
+
+	source := b.source
+	size := source.Size()
+
+	off, err := src.LineOffset(top)
+	if err != nil {
+		off = 0
+	}
+	f, ref, err := timestamp.Detect(source, off, size)
+	if err == timestamp.ErrNotFound && off > 0 {
+		// Perhaps the timestamps are all above the screen.
+		f, ref, err = timestamp.Detect(source, 0, size)
+	}
+	if err != nil {
+		l.message("%v", err)
 		return
 	}
 
-	c := e.Ch
-	k := e.Key
-	// Key is only valid is Ch is 0
+	t, err := timestamp.ParseTarget(s, ref)
+	if err != nil {
+		l.message("%v", err)
+		return
+	}
+
+	offset, err := timestamp.Search(source, size, f, t)
+	if err != nil {
+		l.message("%v", err)
+		return
+	}
+	if offset >= size {
+		l.message("no %s timestamps at or after %s", f, s)
+		return
+	}
+
+	l.gotoOffset(offset)
+}
+
+func (l *Lesser) handleEvent(e termbox.Event) {
+	l.mu.Lock()
+	mode := l.mode
+	l.mu.Unlock()
+
+	if e.Type != termbox.EventKey {
+		return
+	}
+
+	l.mu.Lock()
+	l.notice = ""
+	l.mu.Unlock()
+
+	c := e.Ch
+	k := e.Key
+	// Key is only valid is Ch is 0
 	if c != 0 {
 		k = 0
 	}
 
 	switch mode {
 	case ModeNormal:
+		// Digits typed before a command are its count.
+		if c >= '0' && c <= '9' {
+			l.mu.Lock()
+			// Ignore digits that would overflow.
+			if l.count <= (math.MaxInt64-9)/10 {
+				l.count = l.count*10 + int64(c-'0')
+			}
+			l.counted = true
+			l.mu.Unlock()
+			l.events <- EventRefresh
+			break
+		}
+
+		l.mu.Lock()
+		count, counted := l.count, l.counted
+		l.count, l.counted = 0, false
+		l.mu.Unlock()
+		n := repeat(count, counted)
+
+		// Any other key stops following the end of the file.
+		if c != 'F' {
+			l.mu.Lock()
+			l.following = false
+			l.mu.Unlock()
+		}
+
 		switch {
 		case c == 'q':
 			l.events <- EventQuit
 		case c == 'j':
-			l.scroll(ScrollDown)
+			l.scroll(ScrollDown, n)
 			l.events <- EventRefresh
 		case c == 'k':
-			l.scroll(ScrollUp)
+			l.scroll(ScrollUp, n)
+			l.events <- EventRefresh
+		case (c == 'g' || c == 'G') && counted:
+			l.gotoLine(count)
 			l.events <- EventRefresh
 		case c == 'g':
-			l.scroll(ScrollTop)
+			l.gotoLine(1)
 			l.events <- EventRefresh
 		case c == 'G':
-			l.scroll(ScrollBottom)
+			l.gotoEnd()
+			l.events <- EventRefresh
+		case c == 'p', c == '%':
+			l.gotoPercent(count)
+			l.events <- EventRefresh
+		case c == 'P':
+			l.gotoOffset(count)
+			l.events <- EventRefresh
+		case c == 'F':
+			l.mu.Lock()
+			l.following = true
+			l.mu.Unlock()
+			l.scroll(ScrollBottom, 1)
 			l.events <- EventRefresh
 		case k == termbox.KeyPgup:
-			l.scroll(ScrollUpPage)
+			l.scroll(ScrollUpPage, n)
 			l.events <- EventRefresh
 		case k == termbox.KeyPgdn:
-			l.scroll(ScrollDownPage)
+			l.scroll(ScrollDownPage, n)
 			l.events <- EventRefresh
 		case k == termbox.KeyCtrlU:
-			l.scroll(ScrollUpHalfPage)
+			l.scroll(ScrollUpHalfPage, n)
 			l.events <- EventRefresh
 		case k == termbox.KeyCtrlD:
-			l.scroll(ScrollDownHalfPage)
+			l.scroll(ScrollDownHalfPage, n)
 			l.events <- EventRefresh
-		case c == '/':
+		case k == termbox.KeyArrowRight:
 			l.mu.Lock()
-			l.mode = ModeSearchEntry
+			shift := l.shift()
 			l.mu.Unlock()
+			l.scrollColumns(shift)
 			l.events <- EventRefresh
-		case c == 'n':
+		case k == termbox.KeyArrowLeft:
 			l.mu.Lock()
-			if r, ok := l.searchResults.Next(l.line); ok {
-				l.scrollLine(r.line)
-				l.events <- EventRefresh
+			shift := l.shift()
+			l.mu.Unlock()
+			l.scrollColumns(-shift)
+			l.events <- EventRefresh
+		case c == '/', c == '?':
+			l.mu.Lock()
+			b := l.buf()
+			l.origin = position{line: b.line, row: b.row}
+			l.searchOpts = searchOptions{
+				backward: c == '?',
+				wrap:     l.opts.WrapSearch,
 			}
+			l.startInput(ModeSearchEntry, l.opts.SearchHistory)
+			l.mu.Unlock()
+			l.events <- EventRefresh
+		case c == ']', c == '[':
+			l.mu.Lock()
+			l.mode = ModeHighlightJump
+			l.highlightBackward = c == '['
+			l.highlightRepeat = n
+			l.mu.Unlock()
+			l.events <- EventRefresh
+		case c == '&':
+			l.mu.Lock()
+			l.searchOpts = searchOptions{}
+			l.startInput(ModeFilterEntry, l.opts.SearchHistory)
+			l.mu.Unlock()
+			l.events <- EventRefresh
+		case c == ':':
+			l.mu.Lock()
+			l.mode = ModeCommand
 			l.mu.Unlock()
-		case c == 'N':
+			l.events <- EventRefresh
+		case c == '-':
 			l.mu.Lock()
-			if r, ok := l.searchResults.Prev(l.line); ok {
-				l.scrollLine(r.line)
-				l.events <- EventRefresh
+			l.mode = ModeOption
+			l.mu.Unlock()
+			l.events <- EventRefresh
+		case k == termbox.KeyCtrlC:
+			l.mu.Lock()
+			b := l.buf()
+			results := b.searchResults
+			f := b.filter
+			l.mu.Unlock()
+
+			if results.Running() {
+				results.Cancel()
+				l.message("search cancelled")
+			}
+			if f != nil && f.results.Running() {
+				f.results.Cancel()
+				l.message("filter cancelled")
+			}
+		case c == 'n', c == 'N':
+			if l.recallSearch() {
+				break
 			}
+			l.nextMatch(c == 'N', n)
+		case c == 'm':
+			l.mu.Lock()
+			l.mode = ModeMarkSet
+			l.mu.Unlock()
+			l.events <- EventRefresh
+		case c == '\'':
+			l.mu.Lock()
+			l.mode = ModeMarkJump
 			l.mu.Unlock()
+			l.events <- EventRefresh
+		case k == termbox.KeyCtrlO:
+			l.moveJump(false, n)
+			l.events <- EventRefresh
+		case k == termbox.KeyTab:
+			// ^I
+			l.moveJump(true, n)
+			l.events <- EventRefresh
+		case k == termbox.KeyEsc:
+			// Esc discards the count.
+			l.events <- EventRefresh
 		}
 	case ModeSearchEntry:
-		switch {
-		case k == termbox.KeyEnter:
-			r := l.search(l.regexp)
+		l.mu.Lock()
+		var result inputResult
+		if !l.searchModifier(c, k) {
+			result = l.editInput(c, k)
+		}
+		l.mu.Unlock()
+
+		switch result {
+		case inputEntered:
 			l.mu.Lock()
+			l.endIncsearch()
+			b := l.buf()
+			s := l.input.String()
+			opts := l.searchOpts
 			l.mode = ModeNormal
-			l.regexp = ""
-			l.searchResults = r
-			// Jump to nearest result
-			if r, ok := l.searchResults.Next(l.line); ok {
-				l.scrollLine(r.line)
+			l.input.Reset()
+			l.mu.Unlock()
+
+			// Like less, an empty pattern repeats the last search.
+			h := l.opts.SearchHistory
+			if s == "" && h.Len() > 0 {
+				s = h.Get(h.Len() - 1)
+			}
+			if s == "" {
+				l.message("no previous search")
+				break
+			}
+
+			if err := h.Add(s); err != nil {
+				l.message("saving search history: %v", err)
 			}
+
+			l.runSearch(b, s, opts)
+		case inputCancelled:
+			l.mu.Lock()
+			l.endIncsearch()
+			l.mode = ModeNormal
+			l.input.Reset()
 			l.mu.Unlock()
 			l.events <- EventRefresh
-		default:
+		case inputEdited:
 			l.mu.Lock()
-			l.regexp += string(c)
+			l.incsearch()
 			l.mu.Unlock()
 			l.events <- EventRefresh
 		}
+	case ModeHighlightEntry:
+		l.mu.Lock()
+		result, s := l.promptInput(c, k, true)
+		opts := l.searchOpts
+		l.mu.Unlock()
+
+		if result == inputEntered {
+			if err := l.opts.SearchHistory.Add(s); err != nil {
+				l.message("saving search history: %v", err)
+			}
+			l.toggleHighlight(s, opts)
+		}
+		l.events <- EventRefresh
+	case ModeHighlightJump:
+		l.mu.Lock()
+		l.mode = ModeNormal
+		backward := l.highlightBackward
+		n := l.highlightRepeat
+		l.mu.Unlock()
+
+		if c >= '1' && c <= '9' {
+			l.nextHighlight(int(c-'0'), backward, n)
+		}
+		l.events <- EventRefresh
+	case ModeMarkSet, ModeMarkJump:
+		l.mu.Lock()
+		l.mode = ModeNormal
+		l.mu.Unlock()
+
+		if c != 0 && mode == ModeMarkSet {
+			l.setMark(c)
+		} else if c != 0 {
+			l.gotoMark(c)
+		}
+		l.events <- EventRefresh
+	case ModeTimeEntry:
+		l.mu.Lock()
+		result, s := l.promptInput(c, k, false)
+		l.mu.Unlock()
+
+		if result == inputEntered {
+			l.gotoTime(s)
+		}
+		l.events <- EventRefresh
+	case ModeLineEntry:
+		l.mu.Lock()
+		result, s := l.promptInput(c, k, false)
+		l.mu.Unlock()
+
+		if result == inputEntered {
+			if line, err := strconv.ParseInt(s, 10, 64); err == nil {
+				l.gotoLine(line)
+			} else {
+				l.message("invalid line number %q", s)
+			}
+		}
+		l.events <- EventRefresh
+	case ModeFilterEntry:
+		l.mu.Lock()
+		result, s := l.promptInput(c, k, true)
+		opts := l.searchOpts
+		b := l.buf()
+		l.mu.Unlock()
+
+		if result == inputEntered {
+			if err := l.opts.SearchHistory.Add(s); err != nil {
+				l.message("saving search history: %v", err)
+			}
+
+			// Like less, a leading ! displays the lines that
+			// don't match.
+			invert := strings.HasPrefix(s, "!")
+			l.runFilter(b, strings.TrimPrefix(s, "!"), opts, invert)
+		}
+		l.events <- EventRefresh
+	case ModeCommand:
+		l.mu.Lock()
+		l.mode = ModeNormal
+
+		switch c {
+		case 'n':
+			if l.current+1 < len(l.buffers) {
+				l.current++
+			} else {
+				l.notice = "no next file"
+			}
+		case 'p':
+			if l.current > 0 {
+				l.current--
+			} else {
+				l.notice = "no previous file"
+			}
+		case 'x':
+			l.current = 0
+		case 'h':
+			l.searchOpts = searchOptions{}
+			l.startInput(ModeHighlightEntry, l.opts.SearchHistory)
+		case 't':
+			l.startInput(ModeTimeEntry, nil)
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			l.startInput(ModeLineEntry, nil)
+			l.input.Insert(string(c))
+		}
+
+		l.mu.Unlock()
+		l.events <- EventRefresh
+	case ModeOption:
+		l.mu.Lock()
+		l.mode = ModeNormal
+
+		switch c {
+		case 'S':
+			l.chop = !l.chop
+			if l.chop {
+				l.notice = "chopping long lines"
+			} else {
+				l.notice = "wrapping long lines"
+			}
+			// The current row may no longer exist.
+			b := l.buf()
+			l.setTop(position{line: b.line, row: b.row})
+		case 'i':
+			// Like less, -i and -I turn their mode off if it is
+			// already on.
+			if l.caseMode == CaseSmart {
+				l.caseMode = CaseSensitive
+			} else {
+				l.caseMode = CaseSmart
+			}
+			l.notice = l.caseMode.String()
+		case 'I':
+			if l.caseMode == CaseInsensitive {
+				l.caseMode = CaseSensitive
+			} else {
+				l.caseMode = CaseInsensitive
+			}
+			l.notice = l.caseMode.String()
+		case 'N':
+			l.lineNumbers = !l.lineNumbers
+			if l.lineNumbers {
+				l.notice = "line numbers"
+			} else {
+				l.notice = "no line numbers"
+			}
+			// Lines wrap differently in the narrower display.
+			b := l.buf()
+			l.setTop(position{line: b.line, row: b.row})
+		}
+
+		l.mu.Unlock()
+		l.events <- EventRefresh
+	}
+}
+
+// inputResult is the effect of a key press at a prompt.
+type inputResult int
+
+const (
+	// inputEdited means the text entered may have changed.
+	inputEdited inputResult = iota
+
+	// inputEntered means the text entered is complete.
+	inputEntered
+
+	// inputCancelled means the prompt was abandoned.
+	inputCancelled
+)
+
+// startInput switches to mode, which prompts for text with history h, which
+// may be nil.
+// mu must be held on call.
+func (l *Lesser) startInput(mode Mode, h *history.History) {
+	l.mode = mode
+	l.input.Reset()
+	l.inputHistory = h
+	if h != nil {
+		l.historyIndex = h.Len()
+	}
+}
+
+// recallHistory replaces the text entered at the prompt with the entry n
+// entries newer in the prompt's history, or older if n is negative.  Beyond
+// the newest entry is the text entered before recalling any.
+// mu must be held on call.
+func (l *Lesser) recallHistory(n int) {
+	h := l.inputHistory
+	if h == nil {
+		return
+	}
+
+	i := l.historyIndex + n
+	if i < 0 || i > h.Len() {
+		return
+	}
+
+	if l.historyIndex == h.Len() {
+		l.historyDraft = l.input.String()
+	}
+	l.historyIndex = i
+
+	if i == h.Len() {
+		l.input.Set(l.historyDraft)
+	} else {
+		l.input.Set(h.Get(i))
+	}
+}
+
+// searchModifier applies a key press at the search or filter prompt that
+// changes the search options, returning false if k is not one.  Like less,
+// they are only recognized before any text is entered, leaving the keys for
+// editing after.  Filters and highlights only have the literal option.
+// mu must be held on call.
+func (l *Lesser) searchModifier(c rune, k termbox.Key) bool {
+	if c != 0 || len(l.input.Runes()) != 0 {
+		return false
+	}
+
+	search := l.mode == ModeSearchEntry
+	switch {
+	case k == termbox.KeyCtrlF && search:
+		l.searchOpts.fromEnd = !l.searchOpts.fromEnd
+	case k == termbox.KeyCtrlW && search:
+		l.searchOpts.wrap = !l.searchOpts.wrap
+	case k == termbox.KeyCtrlR:
+		l.searchOpts.literal = !l.searchOpts.literal
+	default:
+		return false
+	}
+
+	return true
+}
+
+// searchPrompt returns the prompt for the search, filter, or highlight being
+// entered,
+// describing its options.
+// mu must be held on call.
+func (l *Lesser) searchPrompt() string {
+	var p string
+	switch {
+	case l.searchOpts.fromEnd && l.searchOpts.backward:
+		p += "Last-line "
+	case l.searchOpts.fromEnd:
+		p += "First-line "
+	}
+	if l.searchOpts.wrap {
+		p += "Wrap "
+	}
+	if l.searchOpts.literal {
+		p += "Regex-off "
+	}
+
+	switch {
+	case l.mode == ModeFilterEntry:
+		return p + "&"
+	case l.mode == ModeHighlightEntry:
+		return p + "Highlight: "
+	case l.searchOpts.backward:
+		return p + "?"
+	default:
+		return p + "/"
+	}
+}
+
+// editInput applies a key press to the text entered at the prompt.  As in
+// handleEvent, k is only valid if c is 0.
+// mu must be held on call.
+func (l *Lesser) editInput(c rune, k termbox.Key) inputResult {
+	e := &l.input
+
+	if c != 0 {
+		e.Insert(string(c))
+		return inputEdited
+	}
+
+	switch k {
+	case termbox.KeyEnter:
+		return inputEntered
+	case termbox.KeyEsc, termbox.KeyCtrlC, termbox.KeyCtrlG:
+		return inputCancelled
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		// Like less, backspacing past the start of the prompt
+		// abandons it.
+		if len(e.Runes()) == 0 {
+			return inputCancelled
+		}
+		e.Backspace()
+	case termbox.KeyDelete, termbox.KeyCtrlD:
+		e.Delete()
+	case termbox.KeyArrowLeft, termbox.KeyCtrlB:
+		e.Left()
+	case termbox.KeyArrowRight, termbox.KeyCtrlF:
+		e.Right()
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		e.Home()
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		e.End()
+	case termbox.KeyCtrlW:
+		e.DeleteWord()
+	case termbox.KeyCtrlU:
+		e.DeleteToStart()
+	case termbox.KeyArrowUp, termbox.KeyCtrlP:
+		l.recallHistory(-1)
+	case termbox.KeyArrowDown, termbox.KeyCtrlN:
+		l.recallHistory(1)
+	case termbox.KeySpace:
+		e.Insert(" ")
+	case termbox.KeyTab:
+		e.Insert("\t")
+	}
+
+	return inputEdited
+}
+
+// promptInput applies a key press to the text entered at a prompt, returning
+// the result and the text.  Unless the text was just edited, the prompt ends.
+// If search is true, keys changing the search options are applied too.
+// mu must be held on call.
+func (l *Lesser) promptInput(c rune, k termbox.Key, search bool) (inputResult, string) {
+	var result inputResult
+	if !search || !l.searchModifier(c, k) {
+		result = l.editInput(c, k)
+	}
+
+	s := l.input.String()
+	if result != inputEdited {
+		l.mode = ModeNormal
+		l.input.Reset()
+	}
+	return result, s
+}
+
+func (l *Lesser) listenEvents() {
+	for {
+		e := termbox.PollEvent()
+		l.handleEvent(e)
+	}
+}
+
+// searchResult describes search matches on a single line.
+type searchResult struct {
+	line    int64
+	matches [][]int
+}
+
+// matchesChar returns true if the search result contains a match for
+// character index c.
+func (s searchResult) matchesChar(c int) bool {
+	for _, match := range s.matches {
+		if len(match) < 2 {
+			continue
+		}
+
+		if c >= match[0] && c < match[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// searchOptions configure a search.
+type searchOptions struct {
+	// backward looks for matches before the top line, rather than after
+	// it.
+	backward bool
+
+	// fromEnd starts looking for matches at the first line of the file,
+	// or the last line if backward, rather than at the top line.
+	fromEnd bool
+
+	// wrap continues from the other end of the file when there are no
+	// more matches.
+	wrap bool
+
+	// literal matches the pattern exactly, rather than as a regexp.
+	literal bool
+}
+
+type searchResults struct {
+	// reg is the search regexp.  It is nil if there is no search.
+	reg *regexp.Regexp
+
+	// opts are the options the search was started with.  n and N move
+	// to the next match in the direction of the search.
+	opts searchOptions
+
+	// literal, if not nil, is the exact bytes to search for, which reg
+	// matches too, but more slowly.  If ignoreCase is true, it matches
+	// regardless of case.
+	literal    []byte
+	ignoreCase bool
+
+	// ctx is cancelled to stop searching.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// extendMu serializes extendSearch.
+	extendMu sync.Mutex
+
+	// changed receives a value when results are added or a search stops,
+	// unless it already holds one.
+	changed chan struct{}
+
+	// mu locks the fields below.
+	mu sync.Mutex
+
+	// lines maps search results for a specific line to an index in results.
+	lines sortedmap.Map
+
+	// results contains the actual search results, in no particular order.
+	results []searchResult
+
+	// first is the line the search began at.  The lines from first
+	// through the end of the file are searched, and then the lines before
+	// first.
+	first int64
+
+	// end is the last line searched from first. All lines from first to
+	// it have been searched. It is searched again when the search is
+	// extended, as it may have been incomplete.
+	end int64
+
+	// wrapEnd is the last line searched before first, or 0 if none have
+	// been. All lines before it have been searched.
+	wrapEnd int64
+
+	// running is the number of searches in progress.
+	running int
+
+	// atEOF is true if the search from first reached the end of the
+	// file.
+	atEOF bool
+}
+
+// Position returns the index of the result for line, counting from 1, and the
+// total number of results.  The index is 0 if it isn't known yet, as some of
+// the lines before line haven't been searched.
+func (s *searchResults) Position(line int64) (n, total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.covered(1, line-1) {
+		return 0, s.lines.Len()
+	}
+	return s.lines.Rank(line) + 1, s.lines.Len()
+}
+
+func NewSearchResults(reg *regexp.Regexp) *searchResults {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &searchResults{
+		reg:     reg,
+		ctx:     ctx,
+		cancel:  cancel,
+		changed: make(chan struct{}, 1),
+		lines:   sortedmap.NewMap(),
+		first:   1,
+		end:     1,
+	}
+}
+
+// restart cancels the search, and returns new results for the same search
+// from the first line, which hasn't started.
+func (s *searchResults) restart() *searchResults {
+	s.Cancel()
+
+	r := NewSearchResults(s.reg)
+	r.opts = s.opts
+	r.literal = s.literal
+	r.ignoreCase = s.ignoreCase
+	return r
+}
+
+// Cancel stops searching.  The results found so far are kept.
+func (s *searchResults) Cancel() {
+	s.cancel()
+}
+
+// Running returns true if a search is in progress.
+func (s *searchResults) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.running > 0
+}
+
+// Searched returns the line the search began at, the last line searched from
+// there, and the last line searched before it, or 0 if none have been.
+func (s *searchResults) Searched() (first, end, wrapEnd int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.first, s.end, s.wrapEnd
+}
+
+// notify sends on changed, unless it already holds a value.
+func (s *searchResults) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Add adds a result, replacing any existing result for the same line.
+func (s *searchResults) Add(r searchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := int64(len(s.results))
+	s.results = append(s.results, r)
+	s.lines.Insert(r.line, i)
+	s.notify()
+}
+
+// setEnd records that every line from first through end has been searched.
+func (s *searchResults) setEnd(end int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.end = end
+	s.notify()
+}
+
+// setWrapEnd records that every line through end has been searched, as well
+// as those from first.
+func (s *searchResults) setWrapEnd(end int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.wrapEnd = end
+	s.notify()
+}
+
+// covered returns true if every line from a through b has been searched.  b
+// may be math.MaxInt64, for the end of the file.
+// mu must be held on call.
+func (s *searchResults) covered(a, b int64) bool {
+	if a > b {
+		return true
+	}
+
+	// The lines before first are searched from the start of the file.
+	if a < s.first {
+		last := b
+		if last > s.first-1 {
+			last = s.first - 1
+		}
+		if s.wrapEnd < last {
+			return false
+		}
+	}
+
+	// The rest are searched from first.
+	if b >= s.first && s.end < b && !s.atEOF {
+		return false
+	}
+
+	return true
+}
+
+// Remove removes the result for a specific line, if any.
+func (s *searchResults) Remove(line int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lines.Delete(line)
+}
+
+// Get finds the result for a specific line, returning ok if found
+func (s *searchResults) Get(line int64) (searchResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i, ok := s.lines.Get(line); ok {
+		return s.results[i], true
+	}
+
+	return searchResult{}, false
+}
+
+// Next returns the search result for the nearest line after line,
+// noninclusive, if one exists.
+func (s *searchResults) Next(line int64) (searchResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, i, err := s.lines.NearestGreater(line)
+	if err != nil {
+		// Probably ErrNoSuchKey, aka none found.
+		return searchResult{}, false
+	}
+
+	return s.results[i], true
+}
+
+// Prev returns the search result for the nearest line before line,
+// noninclusive, if one exists.
+func (s *searchResults) Prev(line int64) (searchResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Search for line - 1, since it may be equal.
+	_, i, err := s.lines.NearestLessEqual(line - 1)
+	if err != nil {
+		// Probably ErrNoSuchKey, aka none found.
+		return searchResult{}, false
+	}
+
+	return s.results[i], true
+}
+
+// nearest returns the search result for the nearest line after line, or
+// before it if backward, noninclusive, if one exists.  certain is false if a
+// nearer line, or any line if none was found, hasn't been searched yet.
+// mu must be held on call.
+func (s *searchResults) nearest(line int64, backward bool) (r searchResult, found, certain bool) {
+	if backward {
+		_, i, err := s.lines.NearestLessEqual(line - 1)
+		if err != nil {
+			return searchResult{}, false, s.covered(1, line-1)
+		}
+		r = s.results[i]
+		return r, true, s.covered(r.line+1, line-1)
+	}
+
+	_, i, err := s.lines.NearestGreater(line)
+	if err != nil {
+		return searchResult{}, false, s.covered(line+1, math.MaxInt64)
+	}
+	r = s.results[i]
+	return r, true, s.covered(line+1, r.line-1)
+}
+
+// Find returns the search result for the nearest line after line, or before
+// it if backward, noninclusive.  If there is none and the search wraps, it
+// returns the first result from the other end of the file, and wrapped is
+// true.  certain is false if a nearer line hasn't been searched yet.
+func (s *searchResults) Find(line int64, backward bool) (r searchResult, found, certain, wrapped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, found, certain = s.nearest(line, backward)
+	if found || !certain || !s.opts.wrap {
+		return r, found, certain, false
+	}
+
+	if backward {
+		line = math.MaxInt64
+	} else {
+		line = 0
+	}
+	r, found, certain = s.nearest(line, backward)
+	return r, found, certain, true
+}
+
+// searchStart returns the line a search with opts looks for matches after,
+// or before if searching backward, when the top line is top.
+func searchStart(opts searchOptions, top int64) int64 {
+	switch {
+	case !opts.fromEnd:
+		return top
+	case opts.backward:
+		return math.MaxInt64
+	default:
+		return 0
+	}
+}
+
+// compileSearch compiles the search pattern s with opts, ignoring case as
+// mode says, and returns whether it does.  If the pattern is literal, it also
+// returns the bytes to search for, which are faster to find than the
+// regexp.
+func compileSearch(s string, opts searchOptions, mode CaseMode) (reg *regexp.Regexp, literal []byte, ignoreCase bool, err error) {
+	expr := s
+	if opts.literal {
+		expr = regexp.QuoteMeta(s)
+	}
+
+	ignoreCase = mode.ignoreCase(s, opts.literal)
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+
+	reg, err = regexp.Compile(expr)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if opts.literal {
+		literal = []byte(s)
+	}
+	return reg, literal, ignoreCase, nil
+}
+
+// runSearch starts searching b for s with opts in the background, replacing
+// its previous search.  The display moves to the first match once it is
+// found.  If s is not a valid regexp, it tells the user and returns false.
+// mu must not be held on call.
+func (l *Lesser) runSearch(b *Buffer, s string, opts searchOptions) bool {
+	l.mu.Lock()
+	mode := l.caseMode
+	l.mu.Unlock()
+
+	reg, literal, ignoreCase, err := compileSearch(s, opts, mode)
+	if err != nil {
+		l.message("%v", err)
+		return false
+	}
+
+	results := NewSearchResults(reg)
+	results.opts = opts
+	results.literal = literal
+	results.ignoreCase = ignoreCase
+
+	l.mu.Lock()
+	top := position{line: b.line, row: b.row}
+	start := searchStart(opts, top.line)
+
+	// A forward search begins at the top line, so the first match is
+	// found without waiting for the lines before it.  A backward search
+	// needs all of those lines anyway.  This must be set before results
+	// are published, as other goroutines may read them.
+	if !opts.backward && start > 1 {
+		results.first = start
+		results.end = start
+	}
+
+	b.searchResults.Cancel()
+	b.searchResults = results
+	l.mu.Unlock()
+
+	l.startSearch(b, results)
+	go l.awaitMatch(b, results, top, start)
+
+	l.events <- EventRefresh
+	return true
+}
+
+// startSearch extends the search of b in results through the end of the
+// file, in the background.
+func (l *Lesser) startSearch(b *Buffer, results *searchResults) {
+	if results.reg == nil {
+		return
+	}
+
+	// Count the search as running from now, not once extendSearch
+	// starts, so awaitMatch doesn't give up before it begins.
+	results.mu.Lock()
+	results.running++
+	results.mu.Unlock()
+
+	go func() {
+		l.extendSearch(b, results)
+
+		results.mu.Lock()
+		results.running--
+		results.mu.Unlock()
+		results.notify()
+
+		// Clear the progress from the status bar.
+		l.events <- EventRefresh
+	}()
+}
+
+// awaitMatch waits for the search in results to find the first displayed
+// match after start, or before it for a backward search, and moves the
+// display to it, unless the display has moved from top since the search
+// started.  If the search finishes first, it tells the user there is no
+// match.
+func (l *Lesser) awaitMatch(b *Buffer, results *searchResults, top position, start int64) {
+	for {
+		// Check first, so a search that finishes after finding
+		// nothing isn't mistaken for a cancelled one.
+		running := results.Running()
+
+		l.mu.Lock()
+		r, found, certain, wrapped := b.find(results, start, results.opts.backward)
+		l.mu.Unlock()
+		if !certain && running {
+			<-results.changed
+			continue
+		}
+
+		// A cancelled search is no longer of interest.
+		if !certain {
+			return
+		}
+
+		l.mu.Lock()
+		moved := b != l.buf() || b.searchResults != results || b.line != top.line || b.row != top.row
+		if found && !moved {
+			l.jumpLine(r.line)
+		}
+		l.mu.Unlock()
+
+		if !moved {
+			l.searchStatus(results, r.line, found, certain, wrapped)
+		}
+		return
+	}
+}
+
+// nextMatch moves the display to the nth next displayed match of the
+// current search, in the direction of the search, or the opposite direction
+// if reverse.
+// mu must not be held on call.
+func (l *Lesser) nextMatch(reverse bool, n int) {
+	l.mu.Lock()
+	b := l.buf()
+	results := b.searchResults
+	r, found, certain, wrapped := b.findN(results, b.line, results.opts.backward != reverse, n)
+	if found {
+		l.jumpLine(r.line)
+	}
+	l.mu.Unlock()
+
+	l.searchStatus(results, r.line, found, certain, wrapped)
+}
+
+// recallSearch starts searching the displayed file for the newest pattern
+// in the search history, if it hasn't been searched yet, so n and N continue
+// the last search from a previous session or file.  It returns true if it
+// started a search.
+// mu must not be held on call.
+func (l *Lesser) recallSearch() bool {
+	h := l.opts.SearchHistory
+
+	l.mu.Lock()
+	b := l.buf()
+	searched := b.searchResults.reg != nil
+	l.mu.Unlock()
+
+	if searched || h.Len() == 0 {
+		return false
+	}
+
+	return l.runSearch(b, h.Get(h.Len()-1), searchOptions{wrap: l.opts.WrapSearch})
+}
+
+// runFilter starts displaying only the lines of b that match s with opts, or
+// those that don't if invert is true, replacing any previous filter.  If s
+// is empty, every line is displayed again.  If s is not a valid regexp, it
+// tells the user and returns false.
+// mu must not be held on call.
+func (l *Lesser) runFilter(b *Buffer, s string, opts searchOptions, invert bool) bool {
+	if s == "" {
+		l.mu.Lock()
+		if b.filter != nil {
+			b.filter.results.Cancel()
+			b.filter = nil
+		}
+		if b == l.buf() {
+			l.setTop(position{line: b.line, row: b.row})
+		}
+		l.mu.Unlock()
+		return true
+	}
+
+	l.mu.Lock()
+	mode := l.caseMode
+	l.mu.Unlock()
+
+	reg, literal, ignoreCase, err := compileSearch(s, opts, mode)
+	if err != nil {
+		l.message("%v", err)
+		return false
+	}
+
+	results := NewSearchResults(reg)
+	results.literal = literal
+	results.ignoreCase = ignoreCase
+	f := &filter{results: results, invert: invert}
+
+	l.mu.Lock()
+	if b.filter != nil {
+		b.filter.results.Cancel()
+	}
+	b.filter = f
+	top := position{line: b.line, row: b.row}
+	l.mu.Unlock()
+
+	l.startSearch(b, results)
+	go l.awaitFilter(b, f, top)
+
+	return true
+}
+
+// awaitFilter waits until the filter f of b displays a line at or after the
+// top line in top, or has searched the entire file, and then displays from
+// there, unless the display has moved since the filter started.
+func (l *Lesser) awaitFilter(b *Buffer, f *filter, top position) {
+	for {
+		// As in awaitMatch, check before looking at the results.
+		running := f.results.Running()
+
+		l.mu.Lock()
+		if b != l.buf() || b.filter != f || b.line != top.line || b.row != top.row {
+			l.mu.Unlock()
+			return
+		}
+
+		_, found := b.nextLine(top.line - 1)
+		if !found && running {
+			l.mu.Unlock()
+			<-f.results.changed
+			continue
+		}
+
+		l.setTop(top)
+		_, shown := b.nextLine(0)
+		l.mu.Unlock()
+
+		// Unless the filter was cancelled, nothing matched.
+		if !shown && f.results.ctx.Err() == nil {
+			l.message("Pattern not found")
+		}
+		l.events <- EventRefresh
+		return
 	}
 }
 
-func (l *Lesser) listenEvents() {
-	for {
-		e := termbox.PollEvent()
-		l.handleEvent(e)
+// incsearchLines is how many lines past the screen incremental search looks
+// for a match.  Pressing enter searches the entire file.
+const incsearchLines = 10000
+
+// incsearch begins an incremental search for the text entered at the search
+// prompt, cancelling any incremental search in progress.  In the background,
+// it looks for the first match where a full search would, moves the display
+// to it, and highlights the matches on the screen.
+// mu must be held on call.
+func (l *Lesser) incsearch() {
+	l.cancelIncsearch()
+
+	s := l.input.String()
+	if s == "" {
+		l.preview = nil
+		l.setTop(l.origin)
+		return
+	}
+
+	// The pattern may be incomplete, so errors aren't reported until
+	// enter is pressed.
+	reg, _, _, err := compileSearch(s, l.searchOpts, l.caseMode)
+	if err != nil {
+		return
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.incsearchCancel = cancel
+	go l.runIncsearch(ctx, l.buf(), reg, l.searchOpts)
 }
 
-// searchResult describes search matches on a single line.
-type searchResult struct {
-	line    int64
-	matches [][]int
-	err     error
+// cancelIncsearch cancels the incremental search in progress, if any.
+// mu must be held on call.
+func (l *Lesser) cancelIncsearch() {
+	if l.incsearchCancel != nil {
+		l.incsearchCancel()
+		l.incsearchCancel = nil
+	}
 }
 
-// matchesChar returns true if the search result contains a match for
-// character index c.
-func (s searchResult) matchesChar(c int) bool {
-	for _, match := range s.matches {
-		if len(match) < 2 {
-			continue
-		}
+// endIncsearch cancels the incremental search in progress, if any, and
+// returns the display to where it was when the search prompt began.
+// mu must be held on call.
+func (l *Lesser) endIncsearch() {
+	l.cancelIncsearch()
+	l.preview = nil
+	l.setTop(l.origin)
+}
 
-		if c >= match[0] && c < match[1] {
-			return true
+// runIncsearch performs an incremental search of b for reg with opts, giving
+// up if ctx is cancelled.
+func (l *Lesser) runIncsearch(ctx context.Context, b *Buffer, reg *regexp.Regexp, opts searchOptions) {
+	l.mu.Lock()
+	src := b.src
+	origin := l.origin
+	rows := int64(l.size.y)
+	l.mu.Unlock()
+
+	// next returns the displayed line after line, or before it if
+	// backward.
+	next := func(line int64, backward bool) (int64, bool) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if backward {
+			return b.prevLine(line)
 		}
+		return b.nextLine(line)
 	}
-	return false
-}
 
-type searchResults struct {
-	// mu locks the fields below.
-	mu sync.Mutex
+	// Look for the first displayed match after the start, or before it,
+	// as a full search would.
+	top := origin.line
+	var found bool
+	line, ok := next(searchStart(opts, origin.line), opts.backward)
+	for n := int64(0); ok && n < rows+incsearchLines; n++ {
+		if ctx.Err() != nil {
+			return
+		}
 
-	// lines maps search results for a specific line to an index in results.
-	lines sortedmap.Map
+		m, err := l.searchLine(src, reg, line)
+		if err != nil {
+			break
+		}
+		if len(m) > 0 {
+			top = line
+			found = true
+			break
+		}
 
-	// results contains the actual search results, in no particular order.
-	results []searchResult
-}
+		line, ok = next(line, opts.backward)
+	}
+
+	// Find the matches to highlight. Every line takes at least one row,
+	// so no more lines than rows are displayed.
+	preview := NewSearchResults(reg)
+	line, ok = top, true
+	for n := int64(0); ok && n < rows; n++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		m, err := l.searchLine(src, reg, line)
+		if err != nil {
+			break
+		}
+		if len(m) > 0 {
+			preview.Add(searchResult{line: line, matches: m})
+		}
 
-func NewSearchResults() searchResults {
-	return searchResults{
-		lines: sortedmap.NewMap(),
+		line, ok = next(line, false)
 	}
-}
 
-// Add adds a result.
-func (s *searchResults) Add(r searchResult) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	l.mu.Lock()
+	// The search may have been cancelled while waiting for mu.
+	if ctx.Err() != nil {
+		l.mu.Unlock()
+		return
+	}
+	l.preview = preview
+	if found {
+		l.scrollLine(top)
+	} else {
+		l.setTop(origin)
+	}
+	l.mu.Unlock()
 
-	i := int64(len(s.results))
-	s.results = append(s.results, r)
-	s.lines.Insert(r.line, i)
+	l.events <- EventRefresh
 }
 
-// Get finds the result for a specific line, returning ok if found
-func (s *searchResults) Get(line int64) (searchResult, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// searchStatus tells the user about a move to the result for line in
+// results, or that there was no result to move to if found is false.  certain
+// and wrapped are as returned by searchResults.Find.
+// mu must not be held on call.
+func (l *Lesser) searchStatus(results *searchResults, line int64, found, certain, wrapped bool) {
+	var suffix string
+	if results.Running() {
+		suffix = " so far"
+	}
+	var prefix string
+	if wrapped {
+		prefix = "search wrapped, "
+	}
 
-	if i, ok := s.lines.Get(line); ok {
-		return s.results[i], true
+	switch {
+	case results.reg == nil:
+		l.message("no previous search")
+	case !found && !certain:
+		l.message("Pattern not found yet")
+	case !found:
+		l.message("Pattern not found")
+	default:
+		n, total := results.Position(line)
+		if n == 0 {
+			l.message("%smatch ? of %d%s", prefix, total, suffix)
+		} else {
+			l.message("%smatch %d of %d%s", prefix, n, total, suffix)
+		}
 	}
+}
 
-	return searchResult{}, false
+// message displays a message to the user, until the next key press.
+// mu must not be held on call, as the main goroutine may be waiting for it.
+func (l *Lesser) message(format string, a ...interface{}) {
+	l.messages <- fmt.Sprintf(format, a...)
 }
 
-// Next returns the search result for the nearest line after line,
-// noninclusive, if one exists.
-func (s *searchResults) Next(line int64) (searchResult, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// extendSearch continues a search of b from the last line searched through
+// the end of the file, and then through the lines before the line the search
+// began at, adding any new matches to results, until the search is
+// cancelled.
+func (l *Lesser) extendSearch(b *Buffer, results *searchResults) {
+	results.extendMu.Lock()
+	defer results.extendMu.Unlock()
 
-	_, i, err := s.lines.NearestGreater(line)
-	if err != nil {
-		// Probably ErrNoSuchKey, aka none found.
-		return searchResult{}, false
+	ctx := results.ctx
+	if ctx.Err() != nil {
+		return
 	}
 
-	return s.results[i], true
-}
+	l.mu.Lock()
+	src := b.src
+	source := b.source
+	l.mu.Unlock()
 
-// Prev returns the search result for the nearest line before line,
-// noninclusive, if one exists.
-func (s *searchResults) Prev(line int64) (searchResult, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	results.mu.Lock()
+	first := results.first
+	start := results.end
+	wrapEnd := results.wrapEnd
+	results.atEOF = false
+	results.mu.Unlock()
 
-	// Search for line - 1, since it may be equal.
-	_, i, err := s.lines.NearestLessEqual(line - 1)
+	// The last line searched may have been incomplete, so it is searched
+	// again, from its offset in the index.
+	offset, err := src.LineOffset(start)
 	if err != nil {
-		// Probably ErrNoSuchKey, aka none found.
-		return searchResult{}, false
+		return
 	}
+	// Any matches it had may have since gone away.
+	results.Remove(start)
 
-	return s.results[i], true
-}
+	s := search.Searcher{Regexp: results.reg, Literal: results.literal, IgnoreCase: results.ignoreCase}
+	if l.opts.Color && !l.opts.SearchEscapes {
+		s.Filter = render.StripEscapes
+	}
 
-func (l *Lesser) search(s string) searchResults {
-	reg, err := regexp.Compile(s)
-	if err != nil {
-		// TODO(prattmic): display a better error
-		log.Printf("regexp failed to compile: %v", err)
-		return NewSearchResults()
+	add := func(m search.Match) {
+		results.Add(searchResult{line: m.Line, matches: m.Matches})
 	}
 
-	resultChan := make(chan searchResult, 100)
+	_, err = s.Search(ctx, source, search.Position{Line: start, Offset: offset}, add, results.setEnd)
+	if err != nil {
+		return
+	}
 
-	searchLine := func(line int64) {
-		r, err := l.src.SearchLine(reg, line)
-		if err != nil {
-			r = nil
-		}
+	results.mu.Lock()
+	results.atEOF = true
+	results.mu.Unlock()
+	results.notify()
 
-		resultChan <- searchResult{
-			line:    line,
-			matches: r,
-			err:     err,
-		}
+	// The lines before first don't change as the file grows, so they are
+	// only searched once.
+	if wrapEnd >= first-1 {
+		return
 	}
 
-	nextLine := int64(1)
-	// Spawn initial search goroutines
-	for ; nextLine <= 5; nextLine++ {
-		go searchLine(nextLine)
+	// They end where first starts.
+	limit, err := src.LineOffset(first)
+	if err != nil {
+		return
+	}
+	offset, err = src.LineOffset(wrapEnd + 1)
+	if err != nil {
+		return
 	}
 
-	results := NewSearchResults()
+	s.Search(ctx, io.NewSectionReader(source, 0, limit), search.Position{Line: wrapEnd + 1, Offset: offset}, add, results.setWrapEnd)
+}
+
+// searchLine finds all matches of reg in line from src, returning byte
+// offsets in the line like Regexp.FindAllIndex.  When displaying colors,
+// escape sequences are skipped, unless configured otherwise.
+func (l *Lesser) searchLine(src *lineio.LineReader, reg *regexp.Regexp, line int64) ([][]int, error) {
+	if !l.opts.Color || l.opts.SearchEscapes {
+		return src.SearchLine(reg, line)
+	}
 
-	var count int64
+	buf, err := src.ReadFullLine(line)
+	if err != nil {
+		return nil, err
+	}
 
-	waitResult := func() searchResult {
-		ret := <-resultChan
-		count += 1
+	stripped, offsets := render.StripEscapes(buf)
 
-		// Only store results with matches.
-		if len(ret.matches) > 0 {
-			results.Add(ret)
+	matches := reg.FindAllIndex(stripped, -1)
+	for _, m := range matches {
+		// Map the end to just after the last matched byte, rather than
+		// the next displayed byte, which may follow an escape sequence.
+		if m[1] > m[0] {
+			m[1] = offsets[m[1]-1] + 1
+		} else {
+			m[1] = offsets[m[1]]
 		}
-
-		return ret
+		m[0] = offsets[m[0]]
 	}
 
-	// Collect results, start searching next lines until we start
-	// hitting EOF.
-	for {
-		r := waitResult()
+	return matches, nil
+}
 
-		// We started hitting errors on a previous line,
-		// there is no reason to search later lines.
-		if r.err != nil {
-			break
+// prompt returns the prompt to display in the status bar, when the last line
+// displayed is bottom.
+// mu must be held on call.
+func (l *Lesser) prompt(bottom int64) string {
+	b := l.buf()
+
+	p := promptInfo{
+		name:   b.name,
+		index:  l.current + 1,
+		files:  len(l.buffers),
+		top:    b.line,
+		bottom: bottom,
+		size:   -1,
+	}
+
+	// The totals aren't known until all of the data has arrived and
+	// been indexed.
+	if !b.streaming {
+		p.size = b.source.Size()
+		if b.src.Populated() {
+			p.lines = b.src.LastLine()
 		}
+	}
 
-		go searchLine(nextLine)
-		nextLine++
+	// The display ends where the next line starts, or at the end of the
+	// file if there is no next line.
+	if offset, err := b.src.LineOffset(bottom + 1); err == nil {
+		p.offset = offset
+	} else {
+		p.offset = b.source.Size()
 	}
 
-	// Collect the remaing results.
-	for count < nextLine-1 {
-		waitResult()
+	return formatPrompt(l.opts.Prompt, p)
+}
+
+// progress describes how much of b the search in results has searched, with
+// verb describing the search.
+// mu must be held on call.
+func (l *Lesser) progress(b *Buffer, results *searchResults, verb string) string {
+	first, end, wrapEnd := results.Searched()
+	start, err1 := b.src.LineOffset(first)
+	offset, err2 := b.src.LineOffset(end)
+	wrapOffset, err3 := b.src.LineOffset(wrapEnd + 1)
+	size := b.source.Size()
+	if err1 != nil || err2 != nil || err3 != nil || size <= 0 {
+		return fmt.Sprintf("(%s)", verb)
 	}
 
-	return results
+	searched := offset - start + wrapOffset
+	return fmt.Sprintf("(%s %d%%)", verb, searched*100/size)
 }
 
-// statusBar renders the status bar.
+// statusBar renders the status bar, when the last line displayed is bottom.
 // mu must be held on call.
-func (l *Lesser) statusBar() {
+func (l *Lesser) statusBar(bottom int64) {
 	// The statusbar is just below the display.
 
 	// Clear the statusbar
@@ -414,99 +2392,401 @@ func (l *Lesser) statusBar() {
 
 	switch l.mode {
 	case ModeNormal:
-		// Just a colon and a cursor
+		b := l.buf()
+
+		// Note that more input is on the way, or that it was cut
+		// short, at the far right.
+		var msg string
+		if b.sourceErr != nil {
+			msg = fmt.Sprintf("(input error: %v)", b.sourceErr)
+		} else if l.notice != "" {
+			msg = fmt.Sprintf("(%s)", l.notice)
+		} else if l.following {
+			msg = "(following)"
+		} else if b.streaming {
+			msg = "(streaming)"
+		} else if !b.src.Populated() {
+			msg = "(indexing)"
+		}
+
+		// How far has the search gotten?
+		if results := b.searchResults; results.Running() {
+			msg = strings.TrimSpace(l.progress(b, results, "searching") + " " + msg)
+		}
+
+		// How far have the highlights gotten?
+		if progress := l.highlightProgress(b); progress != "" {
+			msg = strings.TrimSpace(progress + " " + msg)
+		}
+
+		// Are only some lines displayed?
+		if f := b.filter; f != nil && f.results.Running() {
+			msg = strings.TrimSpace(l.progress(b, f.results, "filtering") + " " + msg)
+		} else if f != nil {
+			msg = strings.TrimSpace("(filtered) " + msg)
+		}
+
+		// How far is the display scrolled horizontally?
+		if l.chop && b.column > 0 {
+			msg = strings.TrimSpace(fmt.Sprintf("column %d %s", b.column+1, msg))
+		}
+
+		// Which file is this?
+		if len(l.buffers) > 1 {
+			msg = strings.TrimSpace(fmt.Sprintf("(file %d of %d) %s", l.current+1, len(l.buffers), msg))
+		}
+		// Show the count being typed at the far right.
+		if l.counted {
+			msg = strings.TrimSpace(fmt.Sprintf("%s %d", msg, l.count))
+		}
+		r := []rune(msg)
+
+		// The prompt and a cursor on the left, leaving room for the
+		// message.
+		prompt := []rune(l.prompt(bottom))
+		if max := l.size.x - len(r) - 2; len(prompt) > max {
+			if max < 0 {
+				max = 0
+			}
+			prompt = prompt[:max]
+		}
+		for i, c := range prompt {
+			termbox.SetCell(i, l.size.y, c, termbox.AttrReverse, 0)
+		}
+		termbox.SetCursor(len(prompt), l.size.y)
+
+		for i, c := range r {
+			termbox.SetCell(l.size.x-len(r)+i, l.size.y, c, 0, 0)
+		}
+	case ModeCommand:
 		termbox.SetCell(0, l.size.y, ':', 0, 0)
 		termbox.SetCursor(1, l.size.y)
-	case ModeSearchEntry:
-		// / and search string
-		termbox.SetCell(0, l.size.y, '/', 0, 0)
-		for i, c := range l.regexp {
-			termbox.SetCell(1+i, l.size.y, c, 0, 0)
+	case ModeOption:
+		termbox.SetCell(0, l.size.y, '-', 0, 0)
+		termbox.SetCursor(1, l.size.y)
+	case ModeSearchEntry, ModeFilterEntry, ModeHighlightEntry:
+		l.drawInput(l.searchPrompt())
+	case ModeLineEntry:
+		l.drawInput(":")
+	case ModeTimeEntry:
+		l.drawInput("Time: ")
+	case ModeMarkSet:
+		termbox.SetCell(0, l.size.y, 'm', 0, 0)
+		termbox.SetCursor(1, l.size.y)
+	case ModeMarkJump:
+		termbox.SetCell(0, l.size.y, '\'', 0, 0)
+		termbox.SetCursor(1, l.size.y)
+	case ModeHighlightJump:
+		c := ']'
+		if l.highlightBackward {
+			c = '['
+		}
+		termbox.SetCell(0, l.size.y, c, 0, 0)
+		termbox.SetCursor(1, l.size.y)
+	}
+}
+
+// drawInput draws prompt followed by the text entered at the prompt on the
+// status bar, scrolled horizontally to keep the cursor on the screen.
+// mu must be held on call.
+func (l *Lesser) drawInput(prompt string) {
+	text := l.input.Runes()
+	cursor := l.input.Cursor()
+
+	// width returns the number of columns to display r. Tabs and other
+	// characters without a width are displayed as a single column.
+	width := func(r rune) int {
+		if w := runewidth.RuneWidth(r); w > 0 {
+			return w
+		}
+		return 1
+	}
+
+	// Drop characters from the start until the cursor fits.
+	start := 0
+	cursorColumn := runewidth.StringWidth(prompt)
+	for _, r := range text[:cursor] {
+		cursorColumn += width(r)
+	}
+	for start < cursor && cursorColumn >= l.size.x {
+		cursorColumn -= width(text[start])
+		start++
+	}
+
+	x := 0
+	for _, r := range prompt {
+		termbox.SetCell(x, l.size.y, r, 0, 0)
+		x += width(r)
+	}
+	for _, r := range text[start:] {
+		w := width(r)
+		if x+w > l.size.x {
+			break
 		}
-		termbox.SetCursor(1+len(l.regexp), l.size.y)
+		if r == '\t' {
+			r = ' '
+		}
+		termbox.SetCell(x, l.size.y, r, 0, 0)
+		x += w
 	}
+
+	termbox.SetCursor(cursorColumn, l.size.y)
 }
 
-// alignUp aligns n up to the next multiple of divisor.
-func alignUp(n, divisor int) int {
-	return n + (divisor - (n % divisor))
+// styleAttributes returns the termbox attributes to display s.
+func styleAttributes(s render.Style) (fg, bg termbox.Attribute) {
+	// render.Color uses the same values as termbox colors.
+	fg = termbox.Attribute(s.Fg)
+	bg = termbox.Attribute(s.Bg)
+
+	if s.Bold {
+		fg |= termbox.AttrBold
+	}
+	if s.Underline {
+		fg |= termbox.AttrUnderline
+	}
+	if s.Reverse {
+		fg |= termbox.AttrReverse
+	}
+
+	return fg, bg
 }
 
 func (l *Lesser) refreshScreen() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	for y := 0; y < l.size.y; y++ {
-		buf := make([]byte, l.size.x)
-		line := l.line + int64(y)
+	b := l.buf()
+	gutter := l.gutterWidth(b)
 
-		_, err := l.src.ReadLine(buf, line)
-		// EOF just means the line was shorter than the display.
-		if err != nil && err != io.EOF {
-			return err
-		}
+	// bottom is the last line displayed.
+	bottom := b.line
 
-		highlight, ok := l.searchResults.Get(line)
+	p := position{line: b.line, row: b.row}
+	for y := 0; y < l.size.y; {
+		exists := b.shows(p.line)
 
-		var displayColumn int
-		for i, c := range buf {
-			if displayColumn >= l.size.x {
-				break
+		// A line that isn't displayed, such as one past the end of
+		// the file, is an empty row.
+		rows := [][]render.Cell{nil}
+		if exists {
+			var err error
+			rows, err = l.lineRows(b, p.line, p.row, l.size.y-y)
+			if err != nil {
+				return err
 			}
+		}
 
-			fg := termbox.ColorDefault
-			bg := termbox.ColorDefault
+		results := b.searchResults
+		if l.preview != nil {
+			results = l.preview
+		}
+		highlight, ok := results.Get(p.line)
+		highlights := l.highlightMatches(b, p.line)
 
-			// Highlight matches
-			if ok && highlight.matchesChar(i) {
-				fg = termbox.ColorBlack
-				bg = termbox.ColorWhite
-			}
+		if exists {
+			bottom = p.line
+		}
 
-			if c == '\t' {
-				// Tabs align the display up to the next
-				// multiple of tabstop.
-				next := alignUp(displayColumn, l.tabStop)
+		for i := 0; i < len(rows) && y < l.size.y; i++ {
+			row := rows[i]
 
-				// Clear the tab spaces
-				for j := displayColumn; j < next; j++ {
-					termbox.SetCell(j, y, ' ', 0, 0)
+			// Number the first row of each line that exists.
+			var number string
+			if p.row+i == 0 && gutter > 0 && exists {
+				number = fmt.Sprintf("%*d ", gutter-1, p.line)
+			}
+			for x := 0; x < gutter; x++ {
+				c := ' '
+				if x < len(number) {
+					c = rune(number[x])
 				}
+				termbox.SetCell(x, y, c, termbox.ColorYellow, 0)
+			}
 
-				displayColumn = next
-			} else {
-				termbox.SetCell(displayColumn, y, rune(c), fg, bg)
-				displayColumn += 1
+			displayColumn := gutter
+			for _, c := range row {
+				fg, bg := styleAttributes(c.Style)
+
+				// Highlight matches of the search, on top of
+				// any other highlights.
+				if ok && highlight.matchesChar(c.Offset) {
+					fg = termbox.ColorBlack
+					bg = termbox.ColorWhite
+				} else if color, ok := l.highlightColor(highlights, c.Offset); ok {
+					fg = termbox.ColorBlack
+					bg = color
+				}
+
+				termbox.SetCell(displayColumn, y, c.Ch, fg, bg)
+				displayColumn += c.Width
+			}
+
+			// Clear the rest of the row.
+			for ; displayColumn < l.size.x; displayColumn++ {
+				termbox.SetCell(displayColumn, y, ' ', 0, 0)
 			}
+
+			y++
 		}
+
+		// Following lines are displayed from their first row. Past
+		// the last line, no line after is displayed either.
+		next, ok := b.nextLine(p.line)
+		if !ok {
+			next = p.line + 1
+		}
+		p = position{line: next}
 	}
 
-	l.statusBar()
+	l.statusBar(bottom)
 
 	termbox.Flush()
 
 	return nil
 }
 
+// update checks the source of b for new data, returning true if the display
+// should be refreshed.
+// Must only be called by the main goroutine.
+func (l *Lesser) update(b *Buffer) bool {
+	change, err := b.source.Update()
+	switch change {
+	case SourceTruncated:
+		l.mu.Lock()
+		b.reset()
+		l.notice = fmt.Sprintf("%s truncated", b.name)
+		l.mu.Unlock()
+	case SourceReplaced:
+		l.mu.Lock()
+		b.reset()
+		l.notice = fmt.Sprintf("%s replaced; reopened", b.name)
+		l.mu.Unlock()
+	}
+
+	l.mu.Lock()
+	current := b == l.buf()
+	l.mu.Unlock()
+
+	changed := change != SourceUnchanged
+	if changed {
+		l.mu.Lock()
+		src := b.src
+		results := b.searchResults
+		f := b.filter
+		highlights := append([]*searchResults(nil), b.highlights...)
+		following := l.following
+		l.mu.Unlock()
+
+		// Index the new lines.
+		go src.Populate()
+
+		// Look for matches in the new lines, and filter them.
+		l.startSearch(b, results)
+		if f != nil {
+			l.startSearch(b, f.results)
+		}
+		for _, h := range highlights {
+			l.startSearch(b, h)
+		}
+
+		if following && current {
+			l.scroll(ScrollBottom, 1)
+		}
+	}
+
+	streaming := b.source.Streaming()
+	indexed := b.src.Populated()
+
+	l.mu.Lock()
+	// The data received before an error is still good, so just
+	// report it and keep going.
+	if err != nil {
+		b.sourceErr = err
+		changed = true
+	}
+	if streaming != b.streaming {
+		b.streaming = streaming
+		changed = true
+	}
+	if indexed != b.indexed {
+		b.indexed = indexed
+		changed = true
+	}
+	// Show the progress of a search, filter, or highlight.
+	if b.searchResults.Running() || (b.filter != nil && b.filter.results.Running()) || l.highlightProgress(b) != "" {
+		changed = true
+	}
+	l.mu.Unlock()
+
+	// Changes to other files aren't visible.
+	return changed && current
+}
+
+// updateAll updates all buffers, returning true if the display should be
+// refreshed.
+// Must only be called by the main goroutine.
+func (l *Lesser) updateAll() bool {
+	var refresh bool
+	for _, b := range l.buffers {
+		if l.update(b) {
+			refresh = true
+		}
+	}
+	return refresh
+}
+
 func (l *Lesser) Run() {
-	// Start populating the LineReader cache, to speed things up later.
-	go l.src.Populate()
+	// Start populating the LineReader caches, to speed things up later.
+	for _, b := range l.buffers {
+		go b.src.Populate()
+	}
 
 	go l.listenEvents()
 
+	l.updateAll()
+
+	if l.following {
+		l.scroll(ScrollBottom, 1)
+	}
+
 	err := l.refreshScreen()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to refresh screen: %v\n", err)
 		return
 	}
 
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+
 	for {
-		e := <-l.events
+		select {
+		case e := <-l.events:
+			switch e {
+			case EventQuit:
+				return
+			case EventRefresh:
+				err = l.refreshScreen()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to refresh screen: %v\n", err)
+					return
+				}
+			}
+		case m := <-l.messages:
+			l.mu.Lock()
+			l.notice = m
+			l.mu.Unlock()
+
+			err = l.refreshScreen()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to refresh screen: %v\n", err)
+				return
+			}
+		case <-ticker.C:
+			if !l.updateAll() {
+				continue
+			}
 
-		switch e {
-		case EventQuit:
-			return
-		case EventRefresh:
 			err = l.refreshScreen()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to refresh screen: %v\n", err)
@@ -516,16 +2796,36 @@ func (l *Lesser) Run() {
 	}
 }
 
-func NewLesser(r io.ReaderAt, ts int) Lesser {
+// NewLesser creates a Lesser displaying buffers, which must not be empty.
+func NewLesser(buffers []*Buffer, opts Options) Lesser {
 	x, y := termbox.Size()
 
+	if opts.SearchHistory == nil {
+		opts.SearchHistory = history.New(history.DefaultSize)
+	}
+	if opts.Marks == nil {
+		opts.Marks = marks.New(marks.DefaultSize)
+	}
+
+	// Start with the marks saved by previous sessions.
+	for _, b := range buffers {
+		if b.path != "" {
+			b.marks = opts.Marks.Get(b.path)
+		}
+	}
+
 	return Lesser{
-		src:     lineio.NewLineReader(r),
-		tabStop: ts,
+		buffers: buffers,
+		opts:    opts,
 		// Save one line for statusbar.
-		size:   size{x: x, y: y - 1},
-		line:   1,
-		events: make(chan Event, 1),
-		mode:   ModeNormal,
+		size:     size{x: x, y: y - 1},
+		events:   make(chan Event, 1),
+		messages: make(chan string, 10),
+		mode:     ModeNormal,
+
+		following:   opts.Follow,
+		chop:        opts.Chop,
+		lineNumbers: opts.LineNumbers,
+		caseMode:    opts.Case,
 	}
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prattmic/lesser/lineio"
+)
+
+func TestHasUpper(t *testing.T) {
+	cases := []struct {
+		s       string
+		literal bool
+		want    bool
+	}{
+		{s: "foo", want: false},
+		{s: "Foo", want: true},
+		{s: "fOo", literal: true, want: true},
+		{s: "émile", want: false},
+		{s: "Émile", want: true},
+		// Letters in escapes don't count.
+		{s: `\S+\d`, want: false},
+		{s: `\p{Greek}`, want: false},
+		{s: `\PL`, want: false},
+		{s: `\Sfoo\pN`, want: false},
+		// Letters anywhere else do, even if not matched literally.
+		{s: `[A-Z]`, want: true},
+		{s: `foo|Bar`, want: true},
+		{s: `(?P<name>x)`, want: true},
+		{s: `\p{Greek}A`, want: true},
+		// Invalid patterns are checked too.
+		{s: `(A`, want: true},
+		{s: `(a`, want: false},
+		// In a literal pattern, backslashes are just characters.
+		{s: `\S`, literal: true, want: true},
+	}
+
+	for _, c := range cases {
+		if got := hasUpper(c.s, c.literal); got != c.want {
+			t.Errorf("hasUpper(%q, %v) got %v want %v", c.s, c.literal, got, c.want)
+		}
+	}
+}
+
+func TestCompileSearch(t *testing.T) {
+	cases := []struct {
+		s       string
+		literal bool
+		mode    CaseMode
+
+		// matches and nomatch are lines the regexp should and should
+		// not match.
+		matches []string
+		nomatch []string
+
+		// fast is the literal bytes to search for, if any, and
+		// ignoreCase whether the search ignores case.
+		fast       string
+		ignoreCase bool
+	}{
+		{
+			s:       "a.c",
+			mode:    CaseSensitive,
+			matches: []string{"abc"},
+			nomatch: []string{"ABC"},
+		},
+		{
+			s:          "a.c",
+			mode:       CaseSmart,
+			matches:    []string{"abc", "ABC"},
+			ignoreCase: true,
+		},
+		{
+			s:       "A.c",
+			mode:    CaseSmart,
+			matches: []string{"Abc"},
+			nomatch: []string{"abc"},
+		},
+		{
+			s:       "a.c",
+			literal: true,
+			mode:    CaseSensitive,
+			matches: []string{"a.c"},
+			nomatch: []string{"abc", "A.C"},
+			fast:    "a.c",
+		},
+		{
+			s:          "a.c",
+			literal:    true,
+			mode:       CaseSmart,
+			matches:    []string{"a.c", "A.C"},
+			nomatch:    []string{"abc"},
+			fast:       "a.c",
+			ignoreCase: true,
+		},
+		{
+			s:       "A.c",
+			literal: true,
+			mode:    CaseSmart,
+			matches: []string{"A.c"},
+			nomatch: []string{"a.c"},
+			fast:    "A.c",
+		},
+		{
+			s:          "A.c",
+			literal:    true,
+			mode:       CaseInsensitive,
+			matches:    []string{"a.C"},
+			fast:       "A.c",
+			ignoreCase: true,
+		},
+	}
+
+	for _, c := range cases {
+		reg, literal, ignoreCase, err := compileSearch(c.s, searchOptions{literal: c.literal}, c.mode)
+		if err != nil {
+			t.Errorf("compileSearch(%q, %v, %v) got err %v", c.s, c.literal, c.mode, err)
+			continue
+		}
+
+		for _, m := range c.matches {
+			if !reg.MatchString(m) {
+				t.Errorf("compileSearch(%q, %v, %v) = %v doesn't match %q", c.s, c.literal, c.mode, reg, m)
+			}
+		}
+		for _, m := range c.nomatch {
+			if reg.MatchString(m) {
+				t.Errorf("compileSearch(%q, %v, %v) = %v matches %q", c.s, c.literal, c.mode, reg, m)
+			}
+		}
+
+		if string(literal) != c.fast || ignoreCase != c.ignoreCase {
+			t.Errorf("compileSearch(%q, %v, %v) got literal %q, %v want %q, %v", c.s, c.literal, c.mode, literal, ignoreCase, c.fast, c.ignoreCase)
+		}
+	}
+
+	if _, _, _, err := compileSearch("(", searchOptions{}, CaseSensitive); err == nil {
+		t.Errorf("compileSearch(%q) got nil err", "(")
+	}
+}
+
+// countingReader is an io.ReaderAt that counts the bytes read from it.
+type countingReader struct {
+	r    io.ReaderAt
+	read int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+// The last rows of a long line are laid out without reading the whole line
+// again.
+func TestLineRowsLongLine(t *testing.T) {
+	const length = 4 << 20
+	c := &countingReader{r: strings.NewReader("first\n" + strings.Repeat("x", length) + "tail\n")}
+	b := &Buffer{src: lineio.NewLineReader(c), line: 1}
+	l := &Lesser{size: size{x: 80, y: 10}, opts: Options{TabStop: 8}}
+
+	rows := l.rowCount(b, 2)
+	if want := (length + 4 + 79) / 80; rows != want {
+		t.Fatalf("rowCount(2) got %d want %d", rows, want)
+	}
+
+	c.read = 0
+	got, err := l.lineRows(b, 2, rows-1, 5)
+	if err != nil {
+		t.Fatalf("lineRows(2, %d) got err %v", rows-1, err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("lineRows(2, %d) got %d rows want 1", rows-1, len(got))
+	}
+
+	var text []rune
+	for _, cell := range got[0] {
+		text = append(text, cell.Ch)
+	}
+	if want := strings.Repeat("x", length%80) + "tail"; string(text) != want {
+		t.Errorf("lineRows(2, %d) got %q want %q", rows-1, string(text), want)
+	}
+	if c.read > length/8 {
+		t.Errorf("lineRows(2, %d) read %d bytes want <= %d", rows-1, c.read, length/8)
+	}
+}
+
+func TestSearchResultsPosition(t *testing.T) {
+	// The search began at line 5, and has searched through line 8.
+	s := testResults(8, false, 6, 8)
+	s.first = 5
+
+	// The matches before line 5 aren't known until the search wraps.
+	if n, total := s.Position(6); n != 0 || total != 2 {
+		t.Errorf("Position(6) got %d, %d want 0, 2", n, total)
+	}
+
+	s.Add(searchResult{line: 2})
+	s.setWrapEnd(4)
+
+	cases := []struct {
+		line  int64
+		n     int
+		total int
+	}{
+		{line: 2, n: 1, total: 3},
+		{line: 6, n: 2, total: 3},
+		{line: 8, n: 3, total: 3},
+	}
+
+	for _, c := range cases {
+		if n, total := s.Position(c.line); n != c.n || total != c.total {
+			t.Errorf("Position(%d) got %d, %d want %d, %d", c.line, n, total, c.n, c.total)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package lineedit implements a single line text editor, for entering text
+// at a prompt.
+package lineedit
+
+import (
+	"unicode"
+)
+
+// Editor is a line of text with a cursor.  The zero value is an empty line.
+type Editor struct {
+	// text is the line being edited.
+	text []rune
+
+	// cursor is the index in text of the character following the cursor.
+	cursor int
+}
+
+// String returns the text of the line.
+func (e *Editor) String() string {
+	return string(e.text)
+}
+
+// Runes returns the text of the line.  It must not be modified.
+func (e *Editor) Runes() []rune {
+	return e.text
+}
+
+// Cursor returns the index of the character following the cursor, in the
+// runes returned by Runes.
+func (e *Editor) Cursor() int {
+	return e.cursor
+}
+
+// Set replaces the line with s, with the cursor at the end.
+func (e *Editor) Set(s string) {
+	e.text = []rune(s)
+	e.cursor = len(e.text)
+}
+
+// Reset empties the line.
+func (e *Editor) Reset() {
+	e.Set("")
+}
+
+// Insert inserts s at the cursor, leaving the cursor after it.  Control
+// characters other than tabs can't be displayed on the line, so they are
+// dropped.
+func (e *Editor) Insert(s string) {
+	var add []rune
+	for _, r := range s {
+		if r == '\t' || !unicode.IsControl(r) {
+			add = append(add, r)
+		}
+	}
+
+	text := make([]rune, 0, len(e.text)+len(add))
+	text = append(text, e.text[:e.cursor]...)
+	text = append(text, add...)
+	text = append(text, e.text[e.cursor:]...)
+
+	e.text = text
+	e.cursor += len(add)
+}
+
+// remove deletes the characters from index start up to end, leaving the
+// cursor at start.
+func (e *Editor) remove(start, end int) {
+	e.text = append(e.text[:start], e.text[end:]...)
+	e.cursor = start
+}
+
+// Backspace deletes the character before the cursor.
+func (e *Editor) Backspace() {
+	if e.cursor > 0 {
+		e.remove(e.cursor-1, e.cursor)
+	}
+}
+
+// Delete deletes the character following the cursor.
+func (e *Editor) Delete() {
+	if e.cursor < len(e.text) {
+		e.remove(e.cursor, e.cursor+1)
+	}
+}
+
+// DeleteWord deletes the word before the cursor, along with any spaces
+// between it and the cursor.
+func (e *Editor) DeleteWord() {
+	start := e.cursor
+	for start > 0 && unicode.IsSpace(e.text[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.text[start-1]) {
+		start--
+	}
+	e.remove(start, e.cursor)
+}
+
+// DeleteToStart deletes everything before the cursor.
+func (e *Editor) DeleteToStart() {
+	e.remove(0, e.cursor)
+}
+
+// Left moves the cursor back one character.
+func (e *Editor) Left() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+}
+
+// Right moves the cursor forward one character.
+func (e *Editor) Right() {
+	if e.cursor < len(e.text) {
+		e.cursor++
+	}
+}
+
+// Home moves the cursor to the start of the line.
+func (e *Editor) Home() {
+	e.cursor = 0
+}
+
+// End moves the cursor to the end of the line.
+func (e *Editor) End() {
+	e.cursor = len(e.text)
+}
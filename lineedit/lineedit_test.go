@@ -0,0 +1,99 @@
+package lineedit
+
+import (
+	"testing"
+)
+
+// edit applies the operations in ops to an empty Editor.  Each operation is
+// a single character naming an Editor method, except that other characters
+// are inserted.
+func edit(ops string) *Editor {
+	var e Editor
+	for _, op := range ops {
+		switch op {
+		case '<':
+			e.Left()
+		case '>':
+			e.Right()
+		case '^':
+			e.Home()
+		case '$':
+			e.End()
+		case 'B':
+			e.Backspace()
+		case 'D':
+			e.Delete()
+		case 'W':
+			e.DeleteWord()
+		case 'U':
+			e.DeleteToStart()
+		default:
+			e.Insert(string(op))
+		}
+	}
+	return &e
+}
+
+func TestEdit(t *testing.T) {
+	cases := []struct {
+		ops    string
+		text   string
+		cursor int
+	}{
+		{ops: "", text: "", cursor: 0},
+		{ops: "abc", text: "abc", cursor: 3},
+		{ops: "abc<<x", text: "axbc", cursor: 2},
+		{ops: "abc^x", text: "xabc", cursor: 1},
+		{ops: "abc^$x", text: "abcx", cursor: 4},
+		{ops: "abc<<<<<", text: "abc", cursor: 0},
+		{ops: "abc>>", text: "abc", cursor: 3},
+		{ops: "abcB", text: "ab", cursor: 2},
+		{ops: "abc^B", text: "abc", cursor: 0},
+		{ops: "abc<D", text: "ab", cursor: 2},
+		{ops: "abcD", text: "abc", cursor: 3},
+		{ops: "abc^D", text: "bc", cursor: 0},
+		{ops: "ab cd  W", text: "ab ", cursor: 3},
+		{ops: "ab cd W", text: "ab ", cursor: 3},
+		{ops: "ab cdWW", text: "", cursor: 0},
+		{ops: "ab cd<W", text: "ab d", cursor: 3},
+		{ops: "ab cd<<U", text: "cd", cursor: 0},
+		{ops: "日本語<B", text: "日語", cursor: 1},
+	}
+
+	for _, c := range cases {
+		e := edit(c.ops)
+		if s := e.String(); s != c.text {
+			t.Errorf("%q: got text %q want %q", c.ops, s, c.text)
+		}
+		if e.Cursor() != c.cursor {
+			t.Errorf("%q: got cursor %d want %d", c.ops, e.Cursor(), c.cursor)
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	var e Editor
+	e.Insert("foo\tbar\r\nbaz\x00")
+	if s := e.String(); s != "foo\tbarbaz" {
+		t.Errorf("got %q want %q", s, "foo\tbarbaz")
+	}
+	if e.Cursor() != 10 {
+		t.Errorf("got cursor %d want 10", e.Cursor())
+	}
+}
+
+func TestSet(t *testing.T) {
+	e := edit("abc<<")
+	e.Set("hello")
+	if s := e.String(); s != "hello" {
+		t.Errorf("got %q want %q", s, "hello")
+	}
+	if e.Cursor() != 5 {
+		t.Errorf("got cursor %d want 5", e.Cursor())
+	}
+
+	e.Reset()
+	if s := e.String(); s != "" || e.Cursor() != 0 {
+		t.Errorf("got %q, cursor %d after Reset", s, e.Cursor())
+	}
+}
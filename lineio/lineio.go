@@ -1,51 +1,102 @@
 package lineio
 
 import (
+	"bytes"
 	"io"
+	"math"
 	"regexp"
+	"sync"
 
 	"github.com/prattmic/lesser/sortedmap"
 )
 
+// scanSize is the number of bytes read at once when scanning for lines.
+const scanSize = 64 << 10
+
 type LineReader struct {
 	src io.ReaderAt
 
 	// offsetCache remembers the offset of various lines in src.
 	// At minimum, line 1 must be prepopulated.
 	offsetCache sortedmap.Map
+
+	// populateMu serializes calls to Populate.
+	populateMu sync.Mutex
+
+	// mu locks the fields below.
+	mu sync.Mutex
+
+	// populated is true if Populate has scanned to the end of src.
+	populated bool
+
+	// tailLine is the last line found, and tailEnd the end of the data
+	// scanned after its start, in which there was no other line.  Later
+	// scans for lines after it resume there, so a long last line isn't
+	// scanned again every time its end is needed.
+	tailLine int64
+	tailEnd  int64
 }
 
 // scanForLine reads from curOffset (which is on curLine), looking for line,
 // returning the offset of line.  If err == io.EOF, offset is the offset of
 // the last valid byte.
 func (l *LineReader) scanForLine(line, curLine, curOffset int64) (offset int64, err error) {
+	_, offset, err = l.scan(curLine, curOffset, func(n, _ int64) bool {
+		return n == line
+	})
+	return offset, err
+}
+
+// scan reads from curOffset (which is on curLine), adding each line found to
+// the offsetCache, until stop returns true for the number and offset of a
+// line, and returns them.  If err == io.EOF, line is the last line, and
+// offset is the offset of the last valid byte.
+func (l *LineReader) scan(curLine, curOffset int64, stop func(line, offset int64) bool) (line, offset int64, err error) {
 	lastGoodOffset := int64(-1)
 
-	for {
-		buf := make([]byte, 128)
+	// A newline at the end of the data already scanned may have begun a
+	// line once more data arrived, so it is scanned again.
+	l.mu.Lock()
+	if curLine == l.tailLine && l.tailEnd-1 > curOffset {
+		curOffset = l.tailEnd - 1
+		lastGoodOffset = curOffset - 1
+	}
+	l.mu.Unlock()
 
+	// Read in large blocks, as each read of a file is a system call.
+	buf := make([]byte, scanSize)
+
+	for {
 		n, err := l.src.ReadAt(buf, curOffset)
 		// Keep looking as long as *something* is returned
 		if n == 0 && err != nil {
+			l.mu.Lock()
+			if curLine > l.tailLine || (curLine == l.tailLine && curOffset > l.tailEnd) {
+				l.tailLine, l.tailEnd = curLine, curOffset
+			}
+			l.mu.Unlock()
+
 			// In the event of EOF, callers want to know the last
 			// byte read, to find the last byte in the last line.
-			return lastGoodOffset, err
+			return curLine, lastGoodOffset, err
 		}
 
-		buf = buf[:n]
+		data := buf[:n]
 
-		for i, b := range buf {
-			if b != '\n' {
-				continue
+		for i := 0; ; {
+			j := bytes.IndexByte(data[i:], '\n')
+			if j < 0 {
+				break
 			}
+			i += j + 1
 
-			offset := curOffset + int64(i) + 1
+			offset := curOffset + int64(i)
 
 			// We haven't read this offset yet; it may not exist.
 			// Read-ahead by a byte to double-check this offset
 			// exists before adding a new line.
 			// This is a common case for files ending in a newline.
-			if offset >= curOffset+int64(len(buf)) {
+			if i >= len(data) {
 				t := make([]byte, 1)
 				_, err = l.src.ReadAt(t, offset)
 				if err != nil {
@@ -57,12 +108,12 @@ func (l *LineReader) scanForLine(line, curLine, curOffset int64) (offset int64,
 
 			l.offsetCache.Insert(curLine, offset)
 
-			if curLine == line {
-				return offset, nil
+			if stop(curLine, offset) {
+				return curLine, offset, nil
 			}
 		}
 
-		curOffset += int64(len(buf))
+		curOffset += int64(len(data))
 		// The last byte in the buffer must have been good if we read it.
 		lastGoodOffset = curOffset - 1
 	}
@@ -70,10 +121,38 @@ func (l *LineReader) scanForLine(line, curLine, curOffset int64) (offset int64,
 
 // Populate scans the file, populating the offsetCache, so that future
 // lookups will be faster.
+//
+// Scanning resumes from the last line already in the offsetCache, so if src
+// grows, calling Populate again will only scan the new data.
 func (l *LineReader) Populate() {
-	// Scan from the start of the file to the maximum possible line,
+	l.populateMu.Lock()
+	defer l.populateMu.Unlock()
+
+	// src may have grown since the last scan.
+	l.mu.Lock()
+	l.populated = false
+	l.mu.Unlock()
+
+	// Line 1 is always present, so this cannot fail.
+	line, offset, _ := l.offsetCache.NearestLessEqual(math.MaxInt64)
+
+	// Scan from the last known line to the maximum possible line,
 	// populating the offsetCache along the way.
-	l.scanForLine(int64(0x7fffffffffffffff), 1, 0)
+	_, err := l.scanForLine(math.MaxInt64, line, offset)
+
+	l.mu.Lock()
+	l.populated = err == io.EOF
+	l.mu.Unlock()
+}
+
+// Populated returns true if Populate has scanned to the end of src, so
+// finding any line is fast.  It is false while a later Populate scans any
+// new data.
+func (l *LineReader) Populated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.populated
 }
 
 // findLine returns the offset of start of line.
@@ -102,6 +181,11 @@ func (l *LineReader) findLineRange(line int64) (start, end int64, err error) {
 	// EOF means there is no next line.  End is the last byte in the file,
 	// if it is positive.
 	if err == io.EOF && end >= 0 {
+		// The newline at the end of the file is not part of the line.
+		b := make([]byte, 1)
+		if _, err := l.src.ReadAt(b, end); err == nil && b[0] == '\n' {
+			end--
+		}
 		return start, end, nil
 	} else if err != nil {
 		return 0, 0, err
@@ -121,16 +205,104 @@ func (l *LineReader) LineExists(line int64) bool {
 	return err == nil
 }
 
+// LineOffset returns the byte offset in src of the start of line.
+func (l *LineReader) LineOffset(line int64) (int64, error) {
+	offset, err := l.findLine(line)
+	if err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// LineAt returns the number of the line containing byte offset in src.  If
+// offset is beyond the end of src, it returns the last line and io.EOF.
+//
+// Only the lines up to offset are scanned, so this is fast even if the rest
+// of src hasn't been scanned yet.
+func (l *LineReader) LineAt(offset int64) (int64, error) {
+	// Line 1 is always present, so this cannot fail.
+	last, lastOffset, _ := l.offsetCache.NearestLessEqual(math.MaxInt64)
+
+	if offset < lastOffset {
+		// Every line before the last one found is known, so search
+		// them for the last line starting at or before offset.
+		lo, hi := int64(1), last
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			start, err := l.findLine(mid)
+			if err != nil {
+				return 0, err
+			}
+			if start <= offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return lo, nil
+	}
+
+	// Otherwise, scan on from the last line found until one starts
+	// after offset.
+	line, end, err := l.scan(last, lastOffset, func(_, start int64) bool {
+		return start > offset
+	})
+	if err == io.EOF {
+		if offset > end {
+			return line, io.EOF
+		}
+		return line, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return line - 1, nil
+}
+
+// LastLine returns the number of the last line in the file.  An empty file
+// has one (empty) line.
+func (l *LineReader) LastLine() int64 {
+	// Find an upper bound by doubling, then binary search between the
+	// last line that exists (lo) and the first that does not (hi).
+	lo, hi := int64(1), int64(2)
+	for l.LineExists(hi) {
+		lo = hi
+		hi *= 2
+	}
+
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if l.LineExists(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
+
 // ReadLine reads up to len(p) bytes from line number line from the source.
 // It returns the numbers of bytes written and any error encountered.
 // If n < len(p), err is set to a non-nil value explaining why.
 // See io.ReaderAt for full description of return values.
 func (l *LineReader) ReadLine(p []byte, line int64) (n int, err error) {
+	return l.ReadLineAt(p, line, 0)
+}
+
+// ReadLineAt is like ReadLine, but reads starting at byte offset off within
+// the line.  If off is beyond the end of the line, it returns io.EOF.
+func (l *LineReader) ReadLineAt(p []byte, line int64, off int64) (n int, err error) {
 	start, end, err := l.findLineRange(line)
 	if err != nil {
 		return 0, err
 	}
 
+	start += off
+	if start > end+1 {
+		return 0, io.EOF
+	}
+
 	var shrunk bool
 	// Only read one line worth of data.
 	size := end - start + 1
@@ -148,9 +320,8 @@ func (l *LineReader) ReadLine(p []byte, line int64) (n int, err error) {
 	return n, err
 }
 
-// SearchLine runs Regexp.FindAllIndex on the given line, providing the same
-// return value.
-func (l *LineReader) SearchLine(r *regexp.Regexp, line int64) ([][]int, error) {
+// ReadFullLine returns the entire contents of line number line.
+func (l *LineReader) ReadFullLine(line int64) ([]byte, error) {
 	start, end, err := l.findLineRange(line)
 	if err != nil {
 		return nil, err
@@ -165,11 +336,22 @@ func (l *LineReader) SearchLine(r *regexp.Regexp, line int64) ([][]int, error) {
 		return nil, err
 	}
 
+	return buf, nil
+}
+
+// SearchLine runs Regexp.FindAllIndex on the given line, providing the same
+// return value.
+func (l *LineReader) SearchLine(r *regexp.Regexp, line int64) ([][]int, error) {
+	buf, err := l.ReadFullLine(line)
+	if err != nil {
+		return nil, err
+	}
+
 	return r.FindAllIndex(buf, -1), nil
 }
 
-func NewLineReader(src io.ReaderAt) LineReader {
-	l := LineReader{
+func NewLineReader(src io.ReaderAt) *LineReader {
+	l := &LineReader{
 		src:         src,
 		offsetCache: sortedmap.NewMap(),
 	}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -116,6 +117,19 @@ Line 9`,
 			},
 		},
 	},
+	// Newline at the end of the file is not part of the last line.
+	{
+		data: "Line 1\nLine 2\n",
+		tests: []lineCase{
+			{
+				line:    2,
+				bufSize: 128,
+				err:     io.EOF,
+				data:    "Line 2",
+				size:    6,
+			},
+		},
+	},
 }
 
 func TestReadLine(t *testing.T) {
@@ -144,6 +158,42 @@ func TestReadLine(t *testing.T) {
 
 }
 
+func TestReadLineAt(t *testing.T) {
+	input := `Line 1
+aaa bbb ccc
+Last line`
+
+	r := NewLineReader(bytes.NewReader([]byte(input)))
+
+	cases := []struct {
+		line    int64
+		off     int64
+		bufSize int
+		err     error
+		data    string
+	}{
+		{line: 2, off: 0, bufSize: 128, err: io.EOF, data: "aaa bbb ccc"},
+		{line: 2, off: 4, bufSize: 128, err: io.EOF, data: "bbb ccc"},
+		{line: 2, off: 4, bufSize: 3, err: nil, data: "bbb"},
+		{line: 2, off: 11, bufSize: 128, err: io.EOF, data: ""},
+		{line: 2, off: 20, bufSize: 128, err: io.EOF, data: ""},
+		{line: 3, off: 5, bufSize: 128, err: io.EOF, data: "line"},
+	}
+
+	for _, c := range cases {
+		buf := make([]byte, c.bufSize)
+
+		n, err := r.ReadLineAt(buf, c.line, c.off)
+		if err != c.err {
+			t.Errorf("ReadLineAt(%d, %d): err got %v want %v", c.line, c.off, err, c.err)
+		}
+
+		if string(buf[:n]) != c.data {
+			t.Errorf("ReadLineAt(%d, %d): buf got '%s' want '%s'", c.line, c.off, buf[:n], c.data)
+		}
+	}
+}
+
 func TestLineExists(t *testing.T) {
 	input := `Line 1
 Line 2
@@ -184,6 +234,274 @@ func TestLineExistsNewline(t *testing.T) {
 	}
 }
 
+func TestReadFullLine(t *testing.T) {
+	input := `Line 1
+aaa bbb ccc
+Last line
+`
+
+	r := NewLineReader(bytes.NewReader([]byte(input)))
+
+	cases := []struct {
+		line int64
+		data string
+		err  error
+	}{
+		{line: 1, data: "Line 1", err: nil},
+		{line: 2, data: "aaa bbb ccc", err: nil},
+		{line: 3, data: "Last line", err: nil},
+		{line: 4, data: "", err: io.EOF},
+	}
+
+	for _, c := range cases {
+		buf, err := r.ReadFullLine(c.line)
+		if err != c.err {
+			t.Errorf("ReadFullLine(%d): err got %v want %v", c.line, err, c.err)
+		}
+
+		if string(buf) != c.data {
+			t.Errorf("ReadFullLine(%d) got '%s' want '%s'", c.line, buf, c.data)
+		}
+	}
+}
+
+// growingReader is an io.ReaderAt whose contents may be appended to.
+type growingReader struct {
+	data []byte
+}
+
+func (g *growingReader) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(g.data).ReadAt(p, off)
+}
+
+// Lines that arrive after Populate are found by a later Populate.
+func TestPopulateGrowing(t *testing.T) {
+	g := &growingReader{data: []byte("Line 1\nLine 2")}
+
+	r := NewLineReader(g)
+	r.Populate()
+
+	if !r.LineExists(2) {
+		t.Errorf("LineExists(2) = false want true")
+	}
+
+	if r.LineExists(3) {
+		t.Errorf("LineExists(3) = true want false")
+	}
+
+	g.data = append(g.data, []byte("\nLine 3\nLine 4")...)
+	r.Populate()
+
+	if !r.LineExists(4) {
+		t.Errorf("LineExists(4) = false want true")
+	}
+
+	buf := make([]byte, 128)
+	n, _ := r.ReadLine(buf, 2)
+	if string(buf[:n]) != "Line 2" {
+		t.Errorf("ReadLine(2) got '%s' want 'Line 2'", buf[:n])
+	}
+
+	n, _ = r.ReadLine(buf, 3)
+	if string(buf[:n]) != "Line 3" {
+		t.Errorf("ReadLine(3) got '%s' want 'Line 3'", buf[:n])
+	}
+}
+
+// countingReader is an io.ReaderAt that counts the bytes read from it.
+type countingReader struct {
+	r    io.ReaderAt
+	read int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+// The end of a long last line is found without scanning it again, and a
+// newline at the end of the data scanned still begins a line once more data
+// arrives.
+func TestReadLineAtLongLastLine(t *testing.T) {
+	g := &growingReader{data: append([]byte("first\n"), bytes.Repeat([]byte("x"), 1<<20)...)}
+	c := &countingReader{r: g}
+
+	r := NewLineReader(c)
+	buf := make([]byte, 4)
+	if n, err := r.ReadLineAt(buf, 2, 1<<20-4); string(buf[:n]) != "xxxx" {
+		t.Fatalf("ReadLineAt(2) got '%s', %v want 'xxxx'", buf[:n], err)
+	}
+
+	c.read = 0
+	if n, err := r.ReadLineAt(buf, 2, 1<<20-4); string(buf[:n]) != "xxxx" {
+		t.Errorf("ReadLineAt(2) again got '%s', %v want 'xxxx'", buf[:n], err)
+	}
+	if c.read > scanSize {
+		t.Errorf("ReadLineAt(2) again read %d bytes want <= %d", c.read, scanSize)
+	}
+
+	g.data = append(g.data, '\n')
+	if r.LineExists(3) {
+		t.Errorf("LineExists(3) = true want false")
+	}
+
+	g.data = append(g.data, "last"...)
+	n, _ := r.ReadLine(buf, 3)
+	if string(buf[:n]) != "last" {
+		t.Errorf("ReadLine(3) got '%s' want 'last'", buf[:n])
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	cases := []struct {
+		data string
+		last int64
+	}{
+		{data: "", last: 1},
+		{data: "Line 1", last: 1},
+		{data: "Line 1\n", last: 1},
+		{data: "Line 1\nLine 2\nLine 3", last: 3},
+		{data: strings.Repeat("Line\n", 1000), last: 1000},
+	}
+
+	for _, c := range cases {
+		r := NewLineReader(bytes.NewReader([]byte(c.data)))
+
+		if last := r.LastLine(); last != c.last {
+			t.Errorf("data: '%s', LastLine() got %d want %d", c.data, last, c.last)
+		}
+	}
+}
+
+func TestLineOffset(t *testing.T) {
+	r := NewLineReader(bytes.NewReader([]byte("Line 1\nLine 2\n\nLine 4")))
+
+	cases := []struct {
+		line   int64
+		offset int64
+		err    error
+	}{
+		{line: 1, offset: 0},
+		{line: 2, offset: 7},
+		{line: 3, offset: 14},
+		{line: 4, offset: 15},
+		{line: 5, err: io.EOF},
+	}
+
+	for _, c := range cases {
+		offset, err := r.LineOffset(c.line)
+		if err != c.err {
+			t.Errorf("LineOffset(%d): err got %v want %v", c.line, err, c.err)
+		}
+		if err == nil && offset != c.offset {
+			t.Errorf("LineOffset(%d) got %d want %d", c.line, offset, c.offset)
+		}
+	}
+}
+
+func TestLineAt(t *testing.T) {
+	data := []byte("Line 1\nLine 2\n\nLine 4")
+
+	cases := []struct {
+		offset int64
+		line   int64
+		err    error
+	}{
+		{offset: 0, line: 1},
+		{offset: 6, line: 1},
+		{offset: 7, line: 2},
+		{offset: 13, line: 2},
+		{offset: 14, line: 3},
+		{offset: 15, line: 4},
+		{offset: 20, line: 4},
+		{offset: 21, line: 4, err: io.EOF},
+	}
+
+	// Lines are found by scanning, and then from the cache once the
+	// file has been scanned.
+	for _, populate := range []bool{false, true} {
+		for _, c := range cases {
+			r := NewLineReader(bytes.NewReader(data))
+			if populate {
+				r.Populate()
+			}
+
+			line, err := r.LineAt(c.offset)
+			if err != c.err {
+				t.Errorf("LineAt(%d) with populate %v: err got %v want %v", c.offset, populate, err, c.err)
+			}
+			if line != c.line {
+				t.Errorf("LineAt(%d) with populate %v got %d want %d", c.offset, populate, line, c.line)
+			}
+		}
+	}
+}
+
+func TestPopulated(t *testing.T) {
+	r := NewLineReader(bytes.NewReader([]byte("Line 1\nLine 2\n")))
+
+	if r.Populated() {
+		t.Errorf("Populated() = true before Populate")
+	}
+
+	r.Populate()
+	if !r.Populated() {
+		t.Errorf("Populated() = false after Populate")
+	}
+}
+
+// blockingReader is a growingReader whose reads wait on release once block is
+// set, after sending on reading.
+type blockingReader struct {
+	growingReader
+	block   bool
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingReader) ReadAt(p []byte, off int64) (int, error) {
+	if b.block {
+		b.reading <- struct{}{}
+		<-b.release
+	}
+	return b.growingReader.ReadAt(p, off)
+}
+
+// Populated is false while Populate scans new data.
+func TestPopulatedGrowing(t *testing.T) {
+	b := &blockingReader{
+		growingReader: growingReader{data: []byte("Line 1\nLine 2\n")},
+		reading:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+
+	r := NewLineReader(b)
+	r.Populate()
+
+	b.data = append(b.data, []byte("Line 3\n")...)
+	b.block = true
+
+	done := make(chan struct{})
+	go func() {
+		r.Populate()
+		close(done)
+	}()
+
+	<-b.reading
+	if r.Populated() {
+		t.Errorf("Populated() = true while populating")
+	}
+
+	b.block = false
+	close(b.release)
+	<-done
+
+	if !r.Populated() {
+		t.Errorf("Populated() = false after Populate")
+	}
+}
+
 func TestSearchLine(t *testing.T) {
 	input := `Line 1
 aaa bbb ccc
@@ -1,55 +1,147 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
-	"syscall"
 
 	"github.com/nsf/termbox-go"
+
+	"github.com/prattmic/lesser/history"
+	"github.com/prattmic/lesser/marks"
+	"github.com/prattmic/lesser/state"
 )
 
 var tabStop = flag.Int("tabstop", 8, "Number of spaces per tab")
 var profile = flag.String("profile", "", "Save profile in this file")
+var follow = flag.Bool("follow", false, "Keep displaying the end of the file as it grows, like tail -f")
+var chop = flag.Bool("S", false, "Chop long lines, rather than wrapping them")
+var shift = flag.Int("shift", 0, "Number of columns to scroll horizontally (default half the screen width)")
+var color = flag.Bool("R", false, "Display colors from ANSI color escape sequences")
+var prompt = flag.String("P", DefaultPrompt, "Status bar prompt format: %f file name, %l lines displayed, %L total lines, %p percent by bytes, %P percent by lines, %o byte offset, %s file size, %i/%m file number/count, %t/%b first/last line")
+var lineNumbers = flag.Bool("N", false, "Display line numbers")
+var noHistory = flag.Bool("no-history", false, "Don't load or save search history")
+var noMarks = flag.Bool("no-saved-marks", false, "Don't load or save marks")
+var wrapSearch = flag.Bool("wrap-search", false, "Continue searches from the other end of the file when there are no more matches")
+var ignoreCase = flag.Bool("i", false, "Ignore case in searches, unless the pattern contains uppercase letters")
+var ignoreAllCase = flag.Bool("I", false, "Ignore case in all searches")
+var searchEscapes = flag.Bool("search-escapes", false, "With -R, match searches against escape sequences as well as the displayed text")
 
-func mmapFile(f *os.File) ([]byte, error) {
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
 	stat, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// loadState calls load with the path of the state file called name, unless
+// disabled, and reports any error loading what it holds.
+func loadState(name, what string, disabled bool, load func(path string) error) {
+	if disabled {
+		return
+	}
+
+	path, err := state.Path(name)
+	if err == nil {
+		err = load(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", what, err)
 	}
+}
+
+// loadSearchHistory loads the search history saved by previous sessions,
+// unless disabled.  If it can't be loaded, the history starts out empty, and
+// isn't saved.
+func loadSearchHistory() *history.History {
+	h := history.New(history.DefaultSize)
+	loadState("history", "search history", *noHistory, func(path string) error {
+		saved, err := history.Load(path, history.DefaultSize)
+		if err == nil {
+			h = saved
+		}
+		return err
+	})
+	return h
+}
+
+// loadMarks loads the marks saved by previous sessions, unless disabled.  If
+// they can't be loaded, there are no marks to start with, and they aren't
+// saved.
+func loadMarks() *marks.Marks {
+	m := marks.New(marks.DefaultSize)
+	loadState("marks", "marks", *noMarks, func(path string) error {
+		saved, err := marks.Load(path, marks.DefaultSize)
+		if err == nil {
+			m = saved
+		}
+		return err
+	})
+	return m
+}
 
-	return syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE)
+func init() {
+	// less calls this option -#.
+	flag.IntVar(shift, "#", 0, "Alias for -shift")
 }
 
 func main() {
 	flag.Parse()
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s: %s filename\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s: %s [filename...]\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "With no filename, or when a filename is -, read standard input.\n")
 		flag.PrintDefaults()
 	}
 
-	if len(flag.Args()) != 1 {
-		flag.Usage()
-		os.Exit(1)
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
 	}
 
-	name := flag.Arg(0)
-	f, err := os.Open(name)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", name, err)
-		os.Exit(1)
-	}
+	var buffers []*Buffer
+	for _, name := range args {
+		var src Source
+		var path string
+		if name == "-" {
+			// Paging the terminal itself makes no sense.
+			if isTerminal(os.Stdin) {
+				flag.Usage()
+				os.Exit(1)
+			}
 
-	m, err := mmapFile(f)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to mmap file: %v\n", err)
-		os.Exit(1)
+			name = "(standard input)"
+			src = newSpoolSource(os.Stdin)
+		} else {
+			var err error
+			src, err = OpenSource(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", name, err)
+				os.Exit(1)
+			}
+
+			// Without a path, marks just aren't saved.
+			path, _ = filepath.Abs(name)
+		}
+		defer src.Close()
+
+		b := NewBuffer(name, src)
+		b.path = path
+		buffers = append(buffers, b)
 	}
-	defer syscall.Munmap(m)
 
-	err = termbox.Init()
+	// Load saved state before termbox takes over the screen, so any
+	// failure to load it is displayed.
+	searchHistory := loadSearchHistory()
+	savedMarks := loadMarks()
+
+	// termbox reads input from /dev/tty, so it is fine if stdin
+	// is the source.
+	err := termbox.Init()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to init: %v\n", err)
 		os.Exit(1)
@@ -67,6 +159,26 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	l := NewLesser(bytes.NewReader(m), *tabStop)
+	caseMode := CaseSensitive
+	if *ignoreAllCase {
+		caseMode = CaseInsensitive
+	} else if *ignoreCase {
+		caseMode = CaseSmart
+	}
+
+	l := NewLesser(buffers, Options{
+		TabStop:       *tabStop,
+		Follow:        *follow,
+		Chop:          *chop,
+		Shift:         *shift,
+		Color:         *color,
+		SearchEscapes: *searchEscapes,
+		LineNumbers:   *lineNumbers,
+		Prompt:        *prompt,
+		SearchHistory: searchHistory,
+		WrapSearch:    *wrapSearch,
+		Case:          caseMode,
+		Marks:         savedMarks,
+	})
 	l.Run()
 }
@@ -0,0 +1,145 @@
+package main
+
+// maxJumps is the maximum number of lines in the jump list.
+const maxJumps = 100
+
+// isMarkName returns true if c can name a mark set with m.
+func isMarkName(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// addJump adds line as the newest entry in the jump list, removing any older
+// entry for it, and the oldest entries beyond the maximum.
+// Lesser.mu must be held on call.
+func (b *Buffer) addJump(line int64) {
+	for i, old := range b.jumps {
+		if old == line {
+			b.jumps = append(b.jumps[:i], b.jumps[i+1:]...)
+			break
+		}
+	}
+
+	b.jumps = append(b.jumps, line)
+
+	if over := len(b.jumps) - maxJumps; over > 0 {
+		b.jumps = b.jumps[over:]
+	}
+}
+
+// pushJump remembers the top line before a large jump, in the jump list and
+// as the mark ', and stops moving through the jump list.
+// Lesser.mu must be held on call.
+func (b *Buffer) pushJump() {
+	b.marks['\''] = b.line
+	b.addJump(b.line)
+	b.jump = len(b.jumps)
+}
+
+// jumpBack returns the line before the current one in the jump list, if
+// any.  The first step back adds the top line to the list, so jumpForward can
+// return to it.
+// Lesser.mu must be held on call.
+func (b *Buffer) jumpBack() (int64, bool) {
+	if b.jump >= len(b.jumps) {
+		b.addJump(b.line)
+		b.jump = len(b.jumps) - 1
+	}
+
+	if b.jump == 0 {
+		return 0, false
+	}
+	b.jump--
+	return b.jumps[b.jump], true
+}
+
+// jumpForward returns the line after the current one in the jump list, if
+// any.
+// Lesser.mu must be held on call.
+func (b *Buffer) jumpForward() (int64, bool) {
+	if b.jump+1 >= len(b.jumps) {
+		return 0, false
+	}
+	b.jump++
+	return b.jumps[b.jump], true
+}
+
+// jumpLine is like scrollLine, but remembers the top line first, so ^O, or
+// the mark ', can return to it.
+// mu must be held on call.
+func (l *Lesser) jumpLine(line int64) {
+	l.buf().pushJump()
+	l.scrollLine(line)
+}
+
+// setMark sets mark name to the top line of the display, and saves it if the
+// file has a path.
+// mu must not be held on call.
+func (l *Lesser) setMark(name rune) {
+	if !isMarkName(name) {
+		l.message("invalid mark %q", name)
+		return
+	}
+
+	l.mu.Lock()
+	b := l.buf()
+	b.marks[name] = b.line
+	path, line := b.path, b.line
+	l.mu.Unlock()
+
+	if path == "" {
+		return
+	}
+	if err := l.opts.Marks.Set(path, name, line); err != nil {
+		l.message("saving marks: %v", err)
+	}
+}
+
+// gotoMark displays the line marked name at the top of the screen.
+// mu must not be held on call.
+func (l *Lesser) gotoMark(name rune) {
+	l.mu.Lock()
+	line, ok := l.buf().marks[name]
+	if ok {
+		l.jumpLine(line)
+	}
+	l.mu.Unlock()
+
+	if !ok {
+		l.message("mark %c not set", name)
+	}
+}
+
+// moveJump moves n entries back through the jump list, or forward if
+// forward is true.
+// mu must not be held on call.
+func (l *Lesser) moveJump(forward bool, n int) {
+	l.mu.Lock()
+	b := l.buf()
+	step := b.jumpBack
+	if forward {
+		step = b.jumpForward
+	}
+
+	var line int64
+	var moved bool
+	for i := 0; i < n; i++ {
+		next, ok := step()
+		if !ok {
+			break
+		}
+		line = next
+		moved = true
+	}
+	if moved {
+		l.scrollLine(line)
+	}
+	l.mu.Unlock()
+
+	switch {
+	case moved:
+	case forward:
+		l.message("no newer jump")
+	default:
+		l.message("no older jump")
+	}
+}
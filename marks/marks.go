@@ -0,0 +1,179 @@
+// Package marks keeps named lines in files, optionally persisted in a file
+// shared between sessions.
+package marks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prattmic/lesser/state"
+)
+
+// DefaultSize is the default maximum number of files with marks.
+const DefaultSize = 100
+
+// file is the marks in one file.
+type file struct {
+	// path is the path of the file.
+	path string
+
+	// lines are the marked line numbers, by mark name.
+	lines map[rune]int64
+}
+
+// Marks are the marks in a number of files, from the least to the most
+// recently marked.
+type Marks struct {
+	// path is the file the marks are saved in, or "" if they are not
+	// saved.
+	path string
+
+	// size is the maximum number of files.
+	size int
+
+	// files are the files with marks, from the least to the most
+	// recently marked.
+	files []*file
+}
+
+// New returns an empty set of marks in at most size files, which is not
+// saved.
+func New(size int) *Marks {
+	return &Marks{size: size}
+}
+
+// Load returns the marks in at most size files saved in the file at path.
+// If the file doesn't exist, there are no marks.  New marks are saved in the
+// file.
+func Load(path string, size int) (*Marks, error) {
+	m := &Marks{path: path, size: size}
+
+	saved, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m.merge(saved)
+
+	return m, nil
+}
+
+// readFile returns the files in the marks file at path.  Each line is a
+// mark: its name, line number, and the path of the file, separated by
+// spaces.  A file that doesn't exist has no marks.
+func readFile(path string) ([]*file, error) {
+	lines, err := state.ReadLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*file
+	for _, l := range lines {
+		fields := strings.SplitN(l, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s: malformed mark %q", path, l)
+		}
+
+		name := []rune(fields[0])
+		line, err := strconv.ParseInt(fields[1], 10, 64)
+		if len(name) != 1 || err != nil {
+			return nil, fmt.Errorf("%s: malformed mark %q", path, l)
+		}
+
+		// Marks in the same file are on consecutive lines.
+		if n := len(files); n == 0 || files[n-1].path != fields[2] {
+			files = append(files, &file{path: fields[2], lines: make(map[rune]int64)})
+		}
+		files[len(files)-1].lines[name[0]] = line
+	}
+
+	return files, nil
+}
+
+// add sets mark name in the file at path to line, making it the most
+// recently marked file, and forgets the least recently marked files beyond
+// the maximum.
+func (m *Marks) add(path string, name rune, line int64) {
+	f := &file{path: path, lines: make(map[rune]int64)}
+	for i, old := range m.files {
+		if old.path == path {
+			f = old
+			m.files = append(m.files[:i], m.files[i+1:]...)
+			break
+		}
+	}
+
+	f.lines[name] = line
+	m.files = append(m.files, f)
+
+	if over := len(m.files) - m.size; over > 0 {
+		m.files = m.files[over:]
+	}
+}
+
+// merge adds the marks in files, from the least to the most recently
+// marked.
+func (m *Marks) merge(files []*file) {
+	for _, f := range files {
+		for name, line := range f.lines {
+			m.add(f.path, name, line)
+		}
+	}
+}
+
+// Get returns the marks in the file at path, by name.  The caller may
+// modify the returned map.
+func (m *Marks) Get(path string) map[rune]int64 {
+	lines := make(map[rune]int64)
+	for _, f := range m.files {
+		if f.path != path {
+			continue
+		}
+		for name, line := range f.lines {
+			lines[name] = line
+		}
+		break
+	}
+	return lines
+}
+
+// Set sets mark name in the file at path to line.  name must not be a space
+// or newline.
+//
+// If the marks are saved, they are first merged with the marks other
+// sessions have saved since, and then the file is updated.  The mark is set
+// even if saving it fails.
+func (m *Marks) Set(path string, name rune, line int64) error {
+	if m.path == "" {
+		m.add(path, name, line)
+		return nil
+	}
+
+	saved, err := readFile(m.path)
+	if err != nil {
+		m.add(path, name, line)
+		return err
+	}
+
+	// Marks in the file are newer than our own, except for this one.
+	files := m.files
+	m.files = nil
+	m.merge(files)
+	m.merge(saved)
+	m.add(path, name, line)
+
+	return m.save()
+}
+
+// save writes the marks to their file.  The file is replaced atomically, so
+// other sessions never read partial marks.
+func (m *Marks) save() error {
+	var lines []string
+	for _, f := range m.files {
+		for name, line := range f.lines {
+			lines = append(lines, fmt.Sprintf("%c %d %s", name, line, f.path))
+		}
+	}
+
+	return state.WriteLines(m.path, lines)
+}
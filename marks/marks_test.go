@@ -0,0 +1,154 @@
+package marks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// paths returns the paths of the files with marks in m, from the least to
+// the most recently marked.
+func paths(m *Marks) []string {
+	var p []string
+	for _, f := range m.files {
+		p = append(p, f.path)
+	}
+	return p
+}
+
+func TestSet(t *testing.T) {
+	type mark struct {
+		path string
+		name rune
+		line int64
+	}
+
+	cases := []struct {
+		set  []mark
+		want []string
+	}{
+		{set: nil, want: nil},
+		{set: []mark{{"a", 'x', 1}, {"b", 'x', 2}}, want: []string{"a", "b"}},
+		{set: []mark{{"a", 'x', 1}, {"b", 'x', 2}, {"a", 'y', 3}}, want: []string{"b", "a"}},
+		{set: []mark{{"a", 'x', 1}, {"b", 'x', 2}, {"c", 'x', 3}, {"d", 'x', 4}}, want: []string{"b", "c", "d"}},
+	}
+
+	for _, c := range cases {
+		m := New(3)
+		for _, k := range c.set {
+			if err := m.Set(k.path, k.name, k.line); err != nil {
+				t.Errorf("Set(%q, %c, %d) got err %v", k.path, k.name, k.line, err)
+			}
+		}
+
+		if got := paths(m); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("after setting %v got files %q want %q", c.set, got, c.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	m := New(DefaultSize)
+	m.Set("a", 'x', 1)
+	m.Set("a", 'y', 2)
+	m.Set("b", 'x', 3)
+	m.Set("a", 'x', 4)
+
+	cases := []struct {
+		path string
+		want map[rune]int64
+	}{
+		{path: "a", want: map[rune]int64{'x': 4, 'y': 2}},
+		{path: "b", want: map[rune]int64{'x': 3}},
+		{path: "c", want: map[rune]int64{}},
+	}
+
+	for _, c := range cases {
+		if got := m.Get(c.path); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Get(%q) got %v want %v", c.path, got, c.want)
+		}
+	}
+
+	// Modifying the result doesn't change the marks.
+	m.Get("a")['x'] = 5
+	if got := m.Get("a")['x']; got != 4 {
+		t.Errorf("after modifying Get result, got %d want 4", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marks")
+	if err != nil {
+		t.Fatalf("TempDir got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lesser", "marks")
+
+	// A missing file has no marks.
+	m, err := Load(path, 3)
+	if err != nil {
+		t.Fatalf("Load got err %v", err)
+	}
+	if len(m.files) != 0 {
+		t.Errorf("got %q from missing file, want none", paths(m))
+	}
+
+	if err := m.Set("/a b", 'x', 10); err != nil {
+		t.Errorf("Set got err %v", err)
+	}
+
+	// Another session sets marks.
+	other, err := Load(path, 3)
+	if err != nil {
+		t.Fatalf("Load got err %v", err)
+	}
+	if got, want := other.Get("/a b"), map[rune]int64{'x': 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load got %v want %v", got, want)
+	}
+	if err := other.Set("/c", 'y', 20); err != nil {
+		t.Errorf("Set got err %v", err)
+	}
+	if err := other.Set("/a b", 'x', 30); err != nil {
+		t.Errorf("Set got err %v", err)
+	}
+
+	// Setting merges the other session's marks.
+	if err := m.Set("/a b", 'z', 40); err != nil {
+		t.Errorf("Set got err %v", err)
+	}
+	want := map[rune]int64{'x': 30, 'z': 40}
+	if got := m.Get("/a b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after merge got %v want %v", got, want)
+	}
+
+	m, err = Load(path, 3)
+	if err != nil {
+		t.Fatalf("Load got err %v", err)
+	}
+	if got := m.Get("/a b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load got %v want %v", got, want)
+	}
+	if got, want := paths(m), []string{"/c", "/a b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load got files %q want %q", got, want)
+	}
+}
+
+func TestLoadMalformed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marks")
+	if err != nil {
+		t.Fatalf("TempDir got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "marks")
+	if err := ioutil.WriteFile(path, []byte("x 1 /a\nxy 2 /b\n"), 0600); err != nil {
+		t.Fatalf("WriteFile got err %v", err)
+	}
+
+	if _, err := Load(path, 3); err == nil {
+		t.Errorf("Load got nil err, want error for malformed mark")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testLesser returns a Lesser displaying a buffer of lines lines.
+func testLesser(lines int) *Lesser {
+	b := testBuffer(lines)
+	b.marks = make(map[rune]int64)
+
+	return &Lesser{
+		buffers:  []*Buffer{b},
+		size:     size{x: 80, y: 10},
+		opts:     Options{TabStop: 8},
+		messages: make(chan string, 10),
+	}
+}
+
+func TestJumpList(t *testing.T) {
+	l := testLesser(100)
+	b := l.buf()
+
+	// Each step jumps to line, or moves n entries back or forward through
+	// the jump list, leaving the top line at want, and jumps in the list.
+	// A move that isn't possible leaves the top line, and tells the user.
+	steps := []struct {
+		action string
+		line   int64
+		n      int
+		want   int64
+		jumps  []int64
+	}{
+		{action: "jump", line: 10, want: 10, jumps: []int64{1}},
+		{action: "jump", line: 20, want: 20, jumps: []int64{1, 10}},
+		// The first step back adds the top line, to return to.
+		{action: "back", n: 1, want: 10, jumps: []int64{1, 10, 20}},
+		{action: "back", n: 1, want: 1, jumps: []int64{1, 10, 20}},
+		{action: "back", n: 1, want: 1, jumps: []int64{1, 10, 20}},
+		{action: "forward", n: 2, want: 20, jumps: []int64{1, 10, 20}},
+		{action: "forward", n: 1, want: 20, jumps: []int64{1, 10, 20}},
+		{action: "back", n: 1, want: 10, jumps: []int64{1, 10, 20}},
+		// Jumping again moves the top line to the end of the list.
+		{action: "jump", line: 40, want: 40, jumps: []int64{1, 20, 10}},
+		{action: "back", n: 2, want: 20, jumps: []int64{1, 20, 10, 40}},
+		{action: "forward", n: 1, want: 10, jumps: []int64{1, 20, 10, 40}},
+		// Moving back from within the list doesn't add the top line.
+		{action: "back", n: 1, want: 20, jumps: []int64{1, 20, 10, 40}},
+		// Counts beyond the end of the list stop there.
+		{action: "forward", n: 5, want: 40, jumps: []int64{1, 20, 10, 40}},
+		{action: "back", n: 5, want: 1, jumps: []int64{1, 20, 10, 40}},
+	}
+
+	for i, s := range steps {
+		before := b.line
+		switch s.action {
+		case "jump":
+			l.mu.Lock()
+			l.jumpLine(s.line)
+			l.mu.Unlock()
+		case "back":
+			l.moveJump(false, s.n)
+		case "forward":
+			l.moveJump(true, s.n)
+		}
+
+		if b.line != s.want {
+			t.Errorf("step %d: %s %d got line %d want %d", i, s.action, s.n, b.line, s.want)
+		}
+		if !reflect.DeepEqual(b.jumps, s.jumps) {
+			t.Errorf("step %d: %s %d left jumps %v want %v", i, s.action, s.n, b.jumps, s.jumps)
+		}
+
+		select {
+		case m := <-l.messages:
+			if b.line != before {
+				t.Errorf("step %d: %s %d moved and got message %q", i, s.action, s.n, m)
+			}
+		default:
+			if s.action != "jump" && b.line == before {
+				t.Errorf("step %d: %s %d didn't move and got no message", i, s.action, s.n)
+			}
+		}
+	}
+}
+
+// Going to the mark ' returns to the top line before the last jump, and
+// going to it again returns to where it was used.
+func TestJumpMarkToggle(t *testing.T) {
+	l := testLesser(100)
+	b := l.buf()
+
+	l.scrollLine(10)
+	l.gotoMark('a')
+	if m := <-l.messages; m != "mark a not set" {
+		t.Errorf("gotoMark(a) got message %q want %q", m, "mark a not set")
+	}
+
+	l.jumpLine(50)
+	for _, want := range []int64{10, 50, 10} {
+		l.gotoMark('\'')
+		if b.line != want {
+			t.Errorf("gotoMark(') got line %d want %d", b.line, want)
+		}
+	}
+}
+
+func TestAddJumpMax(t *testing.T) {
+	var b Buffer
+	for i := int64(1); i <= maxJumps+10; i++ {
+		b.addJump(i)
+	}
+	b.addJump(50)
+
+	if len(b.jumps) != maxJumps {
+		t.Errorf("len(jumps) got %d want %d", len(b.jumps), maxJumps)
+	}
+	if first, last := b.jumps[0], b.jumps[len(b.jumps)-1]; first != 11 || last != 50 {
+		t.Errorf("jumps got %d...%d want 11...50", first, last)
+	}
+}
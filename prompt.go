@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPrompt is the prompt displayed in the status bar, unless configured
+// otherwise.
+const DefaultPrompt = "%f lines %l/%L byte %o/%s %p%%"
+
+// promptInfo describes the display, for formatting the prompt.
+type promptInfo struct {
+	// name is the name of the displayed file.
+	name string
+
+	// index is the index of the displayed file, starting from 1, of
+	// files open files.
+	index int
+	files int
+
+	// top and bottom are the first and last lines displayed.
+	top    int64
+	bottom int64
+
+	// lines is the number of lines in the file, or 0 if not yet known.
+	lines int64
+
+	// offset is the byte offset of the end of the display.
+	offset int64
+
+	// size is the number of bytes in the file, or -1 if not yet known.
+	size int64
+}
+
+// percent returns n as a percentage of total, or "?" if total is unknown.
+func percent(n, total int64) string {
+	if total < 0 {
+		return "?"
+	}
+	if total == 0 {
+		return "100"
+	}
+	return fmt.Sprint(n * 100 / total)
+}
+
+// formatPrompt expands the escapes in format, which are:
+//
+//	%f	file name
+//	%i	file index
+//	%m	number of files
+//	%l	range of lines displayed
+//	%t	first line displayed
+//	%b	last line displayed
+//	%L	number of lines in the file
+//	%o	byte offset of the end of the display
+//	%s	number of bytes in the file
+//	%p	percentage through the file, by bytes
+//	%P	percentage through the file, by lines
+//	%%	a literal %
+//
+// Values that are not known yet, such as the number of lines before the file
+// is indexed, are displayed as "?".  Other characters are displayed as is.
+func formatPrompt(format string, p promptInfo) string {
+	unknown := func(n int64, ok bool) string {
+		if !ok {
+			return "?"
+		}
+		return fmt.Sprint(n)
+	}
+
+	var b strings.Builder
+	r := []rune(format)
+	for i := 0; i < len(r); i++ {
+		if r[i] != '%' || i+1 == len(r) {
+			b.WriteRune(r[i])
+			continue
+		}
+
+		i++
+		switch r[i] {
+		case 'f':
+			b.WriteString(p.name)
+		case 'i':
+			fmt.Fprint(&b, p.index)
+		case 'm':
+			fmt.Fprint(&b, p.files)
+		case 'l':
+			fmt.Fprintf(&b, "%d-%d", p.top, p.bottom)
+		case 't':
+			fmt.Fprint(&b, p.top)
+		case 'b':
+			fmt.Fprint(&b, p.bottom)
+		case 'L':
+			b.WriteString(unknown(p.lines, p.lines > 0))
+		case 'o':
+			fmt.Fprint(&b, p.offset)
+		case 's':
+			b.WriteString(unknown(p.size, p.size >= 0))
+		case 'p':
+			b.WriteString(percent(p.offset, p.size))
+		case 'P':
+			if p.lines > 0 {
+				b.WriteString(percent(p.bottom, p.lines))
+			} else {
+				b.WriteString("?")
+			}
+		case '%':
+			b.WriteRune('%')
+		default:
+			b.WriteRune('%')
+			b.WriteRune(r[i])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestFormatPrompt(t *testing.T) {
+	info := promptInfo{
+		name:   "file.txt",
+		index:  2,
+		files:  3,
+		top:    10,
+		bottom: 40,
+		lines:  200,
+		offset: 500,
+		size:   2000,
+	}
+
+	// The totals aren't known before the file is indexed.
+	unknown := info
+	unknown.lines = 0
+	unknown.size = -1
+
+	empty := info
+	empty.size = 0
+
+	cases := []struct {
+		format string
+		info   promptInfo
+		want   string
+	}{
+		{format: DefaultPrompt, info: info, want: "file.txt lines 10-40/200 byte 500/2000 25%"},
+		{format: DefaultPrompt, info: unknown, want: "file.txt lines 10-40/? byte 500/? ?%"},
+		{format: "%i/%m %t %b", info: info, want: "2/3 10 40"},
+		{format: "%P%% %p%%", info: info, want: "20% 25%"},
+		{format: "%P %L %s", info: unknown, want: "? ? ?"},
+		{format: "%p", info: empty, want: "100"},
+		// Unknown escapes and a trailing % are displayed as is.
+		{format: "%z 100%", info: info, want: "%z 100%"},
+		{format: "", info: info, want: ""},
+	}
+
+	for _, c := range cases {
+		if got := formatPrompt(c.format, c.info); got != c.want {
+			t.Errorf("formatPrompt(%q, %+v) got %q want %q", c.format, c.info, got, c.want)
+		}
+	}
+}
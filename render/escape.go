@@ -0,0 +1,179 @@
+package render
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Color is a terminal color.
+type Color int
+
+const (
+	ColorDefault Color = iota
+	ColorBlack
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorMagenta
+	ColorCyan
+	ColorWhite
+)
+
+// Style describes how a cell is displayed.
+type Style struct {
+	Fg        Color
+	Bg        Color
+	Bold      bool
+	Underline bool
+	Reverse   bool
+}
+
+// escape is the ASCII escape character, which begins escape sequences.
+const escape = 0x1b
+
+// csiLen returns the length of the ANSI CSI escape sequence (ESC [ ... final)
+// at the start of b, and its final byte. If b does not begin with a CSI
+// sequence, it returns 0. If b ends before the sequence does, it returns -1.
+func csiLen(b []byte) (n int, final byte) {
+	if len(b) == 0 || b[0] != escape {
+		return 0, 0
+	}
+	if len(b) == 1 {
+		return -1, 0
+	}
+	if b[1] != '[' {
+		return 0, 0
+	}
+
+	for i := 2; i < len(b); i++ {
+		// Parameter and intermediate bytes
+		if b[i] >= 0x20 && b[i] <= 0x3f {
+			continue
+		}
+		// Final byte
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return i + 1, b[i]
+		}
+		// Anything else is malformed.
+		return 0, 0
+	}
+
+	return -1, 0
+}
+
+// basicColor converts color index n, from 0 to 7, to a Color.
+func basicColor(n int) Color {
+	return ColorBlack + Color(n)
+}
+
+// extendedColor parses the parameters following 38 or 48, which select a
+// 256-color or RGB color. It returns the color, and the number of
+// parameters consumed.  Only the basic colors can be displayed; others are
+// returned as ColorDefault.
+func extendedColor(params []int) (Color, int) {
+	if len(params) == 0 {
+		return ColorDefault, 0
+	}
+
+	switch params[0] {
+	case 5:
+		if len(params) < 2 {
+			return ColorDefault, len(params)
+		}
+		n := params[1]
+		switch {
+		case n < 8:
+			return basicColor(n), 2
+		case n < 16:
+			// Bright variants.
+			return basicColor(n - 8), 2
+		}
+		return ColorDefault, 2
+	case 2:
+		if len(params) < 4 {
+			return ColorDefault, len(params)
+		}
+		return ColorDefault, 4
+	}
+
+	return ColorDefault, 1
+}
+
+// applySGR updates s with the SGR (Select Graphic Rendition) escape sequence
+// parameters in p, the bytes between "ESC [" and "m".
+func applySGR(s Style, p []byte) Style {
+	var params []int
+	for _, f := range bytes.Split(p, []byte{';'}) {
+		// Empty parameters are 0.
+		n, _ := strconv.Atoi(string(f))
+		params = append(params, n)
+	}
+
+	for i := 0; i < len(params); i++ {
+		n := params[i]
+		switch {
+		case n == 0:
+			s = Style{}
+		case n == 1:
+			s.Bold = true
+		case n == 4:
+			s.Underline = true
+		case n == 7:
+			s.Reverse = true
+		case n == 22:
+			s.Bold = false
+		case n == 24:
+			s.Underline = false
+		case n == 27:
+			s.Reverse = false
+		case n >= 30 && n <= 37:
+			s.Fg = basicColor(n - 30)
+		case n == 38:
+			c, used := extendedColor(params[i+1:])
+			s.Fg = c
+			i += used
+		case n == 39:
+			s.Fg = ColorDefault
+		case n >= 40 && n <= 47:
+			s.Bg = basicColor(n - 40)
+		case n == 48:
+			c, used := extendedColor(params[i+1:])
+			s.Bg = c
+			i += used
+		case n == 49:
+			s.Bg = ColorDefault
+		case n >= 90 && n <= 97:
+			s.Fg = basicColor(n - 90)
+		case n >= 100 && n <= 107:
+			s.Bg = basicColor(n - 100)
+		}
+	}
+
+	return s
+}
+
+// StripEscapes removes ANSI CSI escape sequences from b.
+//
+// offsets maps each byte in stripped to its offset in b.  It contains an
+// extra entry, len(b), so that the end of a range in stripped can also be
+// mapped.
+func StripEscapes(b []byte) (stripped []byte, offsets []int) {
+	stripped = make([]byte, 0, len(b))
+	offsets = make([]int, 0, len(b)+1)
+
+	for i := 0; i < len(b); {
+		if n, _ := csiLen(b[i:]); n > 0 {
+			i += n
+			continue
+		}
+
+		stripped = append(stripped, b[i])
+		offsets = append(offsets, i)
+		i++
+	}
+
+	offsets = append(offsets, len(b))
+
+	return stripped, offsets
+}
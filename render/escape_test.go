@@ -0,0 +1,85 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplySGR(t *testing.T) {
+	cases := []struct {
+		start  Style
+		params string
+		want   Style
+	}{
+		{start: Style{}, params: "31", want: Style{Fg: ColorRed}},
+		{start: Style{}, params: "1;4;7", want: Style{Bold: true, Underline: true, Reverse: true}},
+		{start: Style{Fg: ColorRed, Bold: true}, params: "", want: Style{}},
+		{start: Style{Fg: ColorRed, Bold: true}, params: "0", want: Style{}},
+		{start: Style{Fg: ColorRed, Bold: true}, params: "22;39", want: Style{}},
+		{start: Style{}, params: "42", want: Style{Bg: ColorGreen}},
+		{start: Style{}, params: "94", want: Style{Fg: ColorBlue}},
+		{start: Style{}, params: "38;5;3", want: Style{Fg: ColorYellow}},
+		{start: Style{}, params: "38;5;200", want: Style{}},
+		// The RGB color's parameters aren't mistaken for attributes.
+		{start: Style{}, params: "38;2;1;4;7;1", want: Style{Bold: true}},
+	}
+
+	for _, c := range cases {
+		got := applySGR(c.start, []byte(c.params))
+		if got != c.want {
+			t.Errorf("applySGR(%+v, %q) got %+v want %+v", c.start, c.params, got, c.want)
+		}
+	}
+}
+
+func TestLineSGR(t *testing.T) {
+	data := "a\x1b[31mb\x1b[0m\x1b[Kc"
+	want := []Cell{
+		{Ch: 'a', Width: 1, Offset: 0},
+		{Ch: 'b', Width: 1, Offset: 6, Style: Style{Fg: ColorRed}},
+		{Ch: 'c', Width: 1, Offset: 14},
+	}
+
+	cells := Line([]byte(data), Options{TabStop: 8, SGR: true}, false)
+	if !reflect.DeepEqual(cells, want) {
+		t.Errorf("Line(%q) got %+v want %+v", data, cells, want)
+	}
+
+	// Without SGR, escapes are displayed.
+	want = []Cell{
+		{Ch: '^', Width: 1, Offset: 0},
+		{Ch: '[', Width: 1, Offset: 0},
+		{Ch: '[', Width: 1, Offset: 1},
+		{Ch: 'm', Width: 1, Offset: 2},
+	}
+
+	data = "\x1b[m"
+	cells = Line([]byte(data), Options{TabStop: 8}, false)
+	if !reflect.DeepEqual(cells, want) {
+		t.Errorf("Line(%q) got %+v want %+v", data, cells, want)
+	}
+}
+
+func TestStripEscapes(t *testing.T) {
+	cases := []struct {
+		data     string
+		stripped string
+		offsets  []int
+	}{
+		{data: "abc", stripped: "abc", offsets: []int{0, 1, 2, 3}},
+		{data: "a\x1b[1mb", stripped: "ab", offsets: []int{0, 5, 6}},
+		{data: "\x1b[31mab\x1b[m", stripped: "ab", offsets: []int{5, 6, 10}},
+		// Incomplete sequences are left alone.
+		{data: "a\x1b[", stripped: "a\x1b[", offsets: []int{0, 1, 2, 3}},
+	}
+
+	for _, c := range cases {
+		stripped, offsets := StripEscapes([]byte(c.data))
+		if string(stripped) != c.stripped {
+			t.Errorf("StripEscapes(%q) got %q want %q", c.data, stripped, c.stripped)
+		}
+		if !reflect.DeepEqual(offsets, c.offsets) {
+			t.Errorf("StripEscapes(%q) offsets got %v want %v", c.data, offsets, c.offsets)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package render lays out lines of a file as characters on the screen.
+package render
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cell is a character to display, occupying one or more screen columns.
+type Cell struct {
+	// Ch is the character to display.
+	Ch rune
+
+	// Width is the number of screen columns occupied by Ch.
+	Width int
+
+	// Offset is the byte offset in the line of the character that
+	// produced this cell.  Characters displayed as several cells, like
+	// tabs, produce several cells with the same offset.
+	Offset int
+
+	// Style is the style set by escape sequences in the line.
+	Style Style
+}
+
+// Options control how lines are laid out.
+type Options struct {
+	// TabStop is the number of columns per tab.
+	TabStop int
+
+	// SGR enables interpretation of SGR escape sequences, which set the
+	// color and attributes of the following text.  The escape sequences
+	// are not displayed.  Other CSI escape sequences are ignored.
+	SGR bool
+}
+
+// alignUp aligns n up to the next multiple of divisor.
+func alignUp(n, divisor int) int {
+	return n + (divisor - (n % divisor))
+}
+
+// layout accumulates the cells of a line.
+type layout struct {
+	// cells are the cells laid out, if keep is true.
+	cells []Cell
+	keep  bool
+
+	// style is the style of new cells.
+	style Style
+
+	// column is the screen column following the last cell.
+	column int
+
+	// base is the character displayed by the last cell, which combining
+	// marks may compose with, or 0 if there is none.  The cell may have
+	// been laid out before these cells.
+	base rune
+
+	// If width is positive, the cells are counted into rows of width
+	// columns, as Wrap splits them.  rows is the number of rows before
+	// the last, and rowColumn is the number of columns used in the last.
+	width     int
+	rows      int
+	rowColumn int
+
+	// stopRow, if not negative, stops layout before the first
+	// character with a cell in row stopRow or later, when counting rows.
+	// stopped is set if it does.
+	stopRow int
+	stopped bool
+}
+
+// newLayout returns a layout continuing from start.
+func newLayout(start State) *layout {
+	return &layout{
+		style:   start.Style,
+		column:  start.Column,
+		base:    start.base,
+		stopRow: -1,
+	}
+}
+
+// add adds a cell.
+func (l *layout) add(c Cell) {
+	c.Style = l.style
+	if l.keep {
+		l.cells = append(l.cells, c)
+	}
+	l.column += c.Width
+
+	if l.width > 0 {
+		if l.rowColumn+c.Width > l.width && l.rowColumn > 0 {
+			l.rows++
+			l.rowColumn = 0
+		}
+		l.rowColumn += c.Width
+	}
+}
+
+// addString adds cells displaying s, for the character at offset.
+func (l *layout) addString(s string, offset int) {
+	for _, r := range s {
+		l.add(Cell{Ch: r, Width: 1, Offset: offset})
+	}
+	l.base = 0
+}
+
+// combine attaches combining mark r, at offset, to the last cell.  Terminal
+// cells hold a single character, so the mark can only be attached if the two
+// compose to a single character.  Otherwise the mark is displayed as its code
+// point (<U+0301>).  Composing needs the Unicode normalization tables from
+// golang.org/x/text, as the standard library has none.
+func (l *layout) combine(r rune, offset int) {
+	if l.base != 0 {
+		composed := norm.NFC.String(string([]rune{l.base, r}))
+		if utf8.RuneCountInString(composed) == 1 {
+			l.base, _ = utf8.DecodeRuneInString(composed)
+			if len(l.cells) > 0 {
+				l.cells[len(l.cells)-1].Ch = l.base
+			}
+			return
+		}
+	}
+
+	l.addString(fmt.Sprintf("<U+%04X>", r), offset)
+	l.base = 0
+}
+
+// State is the layout state at a point within a line.
+type State struct {
+	// Offset is the byte offset in the line.
+	Offset int
+
+	// Column is the screen column.
+	Column int
+
+	// Style is the style set by preceding escape sequences.
+	Style Style
+
+	// base is the character before Offset that combining marks at
+	// Offset compose with, if any.
+	base rune
+}
+
+// run lays out b, which begins at start in the line, and which l continues
+// from.  If stop is not negative, layout stops before the first character at
+// or beyond column stop.  It returns the state following the last character
+// laid out.
+func (l *layout) run(b []byte, start State, opts Options, truncated bool, stop int) State {
+	// state returns the state at offset i in b.
+	state := func(i int) State {
+		return State{
+			Offset: start.Offset + i,
+			Column: l.column,
+			Style:  l.style,
+			base:   l.base,
+		}
+	}
+
+	for i := 0; i < len(b); {
+		if opts.SGR && b[i] == escape {
+			n, final := csiLen(b[i:])
+			if n > 0 {
+				if final == 'm' {
+					l.style = applySGR(l.style, b[i+2:i+n-1])
+				}
+				i += n
+				continue
+			}
+			// The rest of the sequence is beyond b.
+			if n < 0 && truncated {
+				return state(i)
+			}
+		}
+
+		r, size := utf8.DecodeRune(b[i:])
+		offset := start.Offset + i
+
+		// Combining marks belong with the character before them, so
+		// they don't begin the next column.
+		mark := r >= utf8.RuneSelf && unicode.Is(unicode.Mn, r)
+		if stop >= 0 && l.column >= stop && !mark {
+			return state(i)
+		}
+
+		// The state before the character, in case it reaches
+		// stopRow.
+		var before State
+		var beforeRows, beforeColumn int
+		if l.stopRow >= 0 {
+			before = state(i)
+			beforeRows, beforeColumn = l.rows, l.rowColumn
+		}
+
+		switch {
+		case r == utf8.RuneError && size == 1:
+			if truncated && !utf8.FullRune(b[i:]) {
+				return state(i)
+			}
+			l.addString(fmt.Sprintf("<%02X>", b[i]), offset)
+		case r == '\t':
+			// Tabs align the display up to the next
+			// multiple of tabstop.
+			next := alignUp(l.column, opts.TabStop)
+			for l.column < next {
+				l.add(Cell{Ch: ' ', Width: 1, Offset: offset})
+			}
+			l.base = 0
+		case r < 0x20 || r == 0x7f:
+			l.addString(string([]rune{'^', r ^ 0x40}), offset)
+		case mark:
+			l.combine(r, offset)
+		default:
+			// Other zero-width characters cannot be displayed.
+			w := 1
+			if r >= utf8.RuneSelf {
+				w = runewidth.RuneWidth(r)
+			}
+			if w > 0 {
+				l.add(Cell{Ch: r, Width: w, Offset: offset})
+				l.base = r
+			} else {
+				l.base = 0
+			}
+		}
+
+		i += size
+
+		if l.stopRow >= 0 && l.rows >= l.stopRow {
+			l.rows, l.rowColumn = beforeRows, beforeColumn
+			l.stopped = true
+			return before
+		}
+	}
+
+	return state(len(b))
+}
+
+// Line lays out the UTF-8 encoded line b as cells.
+//
+// Tabs are expanded to the next multiple of opts.TabStop columns.  Control
+// characters are displayed in caret notation (^A), and bytes that are not
+// valid UTF-8 are displayed as their hex value (<E9>).
+//
+// If truncated is true, b contains only the start of the line, so an
+// incomplete UTF-8 sequence at the end of b is dropped rather than
+// displayed as invalid.
+func Line(b []byte, opts Options, truncated bool) []Cell {
+	return LineFrom(b, State{}, opts, truncated)
+}
+
+// LineFrom is like Line, but b begins partway through the line, at start.
+// start is typically returned by Skip.
+func LineFrom(b []byte, start State, opts Options, truncated bool) []Cell {
+	l := newLayout(start)
+	l.cells = make([]Cell, 0, len(b))
+	l.keep = true
+	l.run(b, start, opts, truncated, -1)
+	return l.cells
+}
+
+// Skip lays out b, which begins at start in the line, until reaching the
+// first character at or beyond column.  It returns the state at that
+// character.  If b ends first, it returns the state at the end of b, from
+// which layout may continue with the following bytes in the line.
+func Skip(b []byte, start State, column int, opts Options, truncated bool) State {
+	return newLayout(start).run(b, start, opts, truncated, column)
+}
+
+// Rows is the state of counting the rows of a wrapped line, at a point
+// within the line.
+type Rows struct {
+	State
+
+	// rows is the number of rows before the last, and column is the
+	// number of columns used in the last.
+	rows, column int
+}
+
+// Count returns the number of rows counted.  Like Wrap, there is always at
+// least one row.
+func (r Rows) Count() int {
+	return r.rows + 1
+}
+
+// CountRows lays out b, which begins at start in the line, and counts the
+// rows Wrap would split it into with width columns, without keeping the cells.
+// It returns the state at the end of b, from which counting may continue with
+// the following bytes in the line.
+func CountRows(b []byte, start Rows, width int, opts Options, truncated bool) Rows {
+	l := newLayout(start.State)
+	l.width = width
+	l.rows = start.rows
+	l.rowColumn = start.column
+
+	state := l.run(b, start.State, opts, truncated, -1)
+	return Rows{State: state, rows: l.rows, column: l.rowColumn}
+}
+
+// SkipRows is like CountRows, but stops before the first character with a
+// cell in row or a later row of the line, so that laying out the line from the
+// returned state with LineRows includes all of row, and returns true.  If b
+// ends first, it returns the state at the end of b, and false.
+func SkipRows(b []byte, start Rows, row, width int, opts Options, truncated bool) (Rows, bool) {
+	l := newLayout(start.State)
+	l.width = width
+	l.rows = start.rows
+	l.rowColumn = start.column
+	l.stopRow = row
+
+	state := l.run(b, start.State, opts, truncated, -1)
+	return Rows{State: state, rows: l.rows, column: l.rowColumn}, l.stopped
+}
+
+// LineRows lays out b, which begins at start in the line, and splits the
+// cells into rows of width columns, like Wrap.  The first row returned is row
+// start.Count()-1 of the line, which only has the cells from start on.
+func LineRows(b []byte, start Rows, width int, opts Options, truncated bool) [][]Cell {
+	return wrap(LineFrom(b, start.State, opts, truncated), width, start.column)
+}
+
+// Wrap splits cells into rows of at most width columns.  A character wider
+// than the remaining space on a row begins the next row.  There is always at
+// least one row, though it may be empty.
+func Wrap(cells []Cell, width int) [][]Cell {
+	return wrap(cells, width, 0)
+}
+
+// wrap is like Wrap, but the first row already has column columns used.
+func wrap(cells []Cell, width, column int) [][]Cell {
+	var rows [][]Cell
+
+	var start int
+	for i, c := range cells {
+		if column+c.Width > width && column > 0 {
+			rows = append(rows, cells[start:i])
+			start = i
+			column = 0
+		}
+		column += c.Width
+	}
+
+	return append(rows, cells[start:])
+}
@@ -0,0 +1,318 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLine(t *testing.T) {
+	cases := []struct {
+		data      string
+		truncated bool
+		cells     []Cell
+	}{
+		{
+			data: "ab",
+			cells: []Cell{
+				{Ch: 'a', Width: 1, Offset: 0},
+				{Ch: 'b', Width: 1, Offset: 1},
+			},
+		},
+		// Multi-byte characters
+		{
+			data: "héllo",
+			cells: []Cell{
+				{Ch: 'h', Width: 1, Offset: 0},
+				{Ch: 'é', Width: 1, Offset: 1},
+				{Ch: 'l', Width: 1, Offset: 3},
+				{Ch: 'l', Width: 1, Offset: 4},
+				{Ch: 'o', Width: 1, Offset: 5},
+			},
+		},
+		// Wide characters
+		{
+			data: "日本a",
+			cells: []Cell{
+				{Ch: '日', Width: 2, Offset: 0},
+				{Ch: '本', Width: 2, Offset: 3},
+				{Ch: 'a', Width: 1, Offset: 6},
+			},
+		},
+		// Combining marks compose with the base character.
+		{
+			data: "éx",
+			cells: []Cell{
+				{Ch: 'é', Width: 1, Offset: 0},
+				{Ch: 'x', Width: 1, Offset: 3},
+			},
+		},
+		// Several marks compose in turn.
+		{
+			data: "e\u0323\u0302",
+			cells: []Cell{
+				{Ch: 'ệ', Width: 1, Offset: 0},
+			},
+		},
+		// Marks that don't compose are displayed as their code point.
+		{
+			data: "x\u0301",
+			cells: []Cell{
+				{Ch: 'x', Width: 1, Offset: 0},
+				{Ch: '<', Width: 1, Offset: 1},
+				{Ch: 'U', Width: 1, Offset: 1},
+				{Ch: '+', Width: 1, Offset: 1},
+				{Ch: '0', Width: 1, Offset: 1},
+				{Ch: '3', Width: 1, Offset: 1},
+				{Ch: '0', Width: 1, Offset: 1},
+				{Ch: '1', Width: 1, Offset: 1},
+				{Ch: '>', Width: 1, Offset: 1},
+			},
+		},
+		// As are marks with no character to compose with.
+		{
+			data: "\t\u0338",
+			cells: []Cell{
+				{Ch: ' ', Width: 1, Offset: 0},
+				{Ch: ' ', Width: 1, Offset: 0},
+				{Ch: ' ', Width: 1, Offset: 0},
+				{Ch: ' ', Width: 1, Offset: 0},
+				{Ch: '<', Width: 1, Offset: 1},
+				{Ch: 'U', Width: 1, Offset: 1},
+				{Ch: '+', Width: 1, Offset: 1},
+				{Ch: '0', Width: 1, Offset: 1},
+				{Ch: '3', Width: 1, Offset: 1},
+				{Ch: '3', Width: 1, Offset: 1},
+				{Ch: '8', Width: 1, Offset: 1},
+				{Ch: '>', Width: 1, Offset: 1},
+			},
+		},
+		// Invalid UTF-8
+		{
+			data: "a\xe9b",
+			cells: []Cell{
+				{Ch: 'a', Width: 1, Offset: 0},
+				{Ch: '<', Width: 1, Offset: 1},
+				{Ch: 'E', Width: 1, Offset: 1},
+				{Ch: '9', Width: 1, Offset: 1},
+				{Ch: '>', Width: 1, Offset: 1},
+				{Ch: 'b', Width: 1, Offset: 2},
+			},
+		},
+		// Incomplete sequence at the end of a truncated line is dropped.
+		{
+			data:      "a\xe6\x97",
+			truncated: true,
+			cells: []Cell{
+				{Ch: 'a', Width: 1, Offset: 0},
+			},
+		},
+		// Control characters
+		{
+			data: "\x01",
+			cells: []Cell{
+				{Ch: '^', Width: 1, Offset: 0},
+				{Ch: 'A', Width: 1, Offset: 0},
+			},
+		},
+		// Tabs
+		{
+			data: "a\tb",
+			cells: []Cell{
+				{Ch: 'a', Width: 1, Offset: 0},
+				{Ch: ' ', Width: 1, Offset: 1},
+				{Ch: ' ', Width: 1, Offset: 1},
+				{Ch: ' ', Width: 1, Offset: 1},
+				{Ch: 'b', Width: 1, Offset: 2},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		cells := Line([]byte(c.data), Options{TabStop: 4}, c.truncated)
+		if !reflect.DeepEqual(cells, c.cells) {
+			t.Errorf("Line(%q) got %+v want %+v", c.data, cells, c.cells)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	cases := []struct {
+		data  string
+		width int
+		rows  []string
+	}{
+		{data: "", width: 4, rows: []string{""}},
+		{data: "abcd", width: 4, rows: []string{"abcd"}},
+		{data: "abcdefghi", width: 4, rows: []string{"abcd", "efgh", "i"}},
+		// Wide characters are not split.
+		{data: "abc日本", width: 4, rows: []string{"abc", "日本"}},
+	}
+
+	for _, c := range cases {
+		rows := Wrap(Line([]byte(c.data), Options{TabStop: 8}, false), c.width)
+
+		var got []string
+		for _, row := range rows {
+			var s []rune
+			for _, cell := range row {
+				s = append(s, cell.Ch)
+			}
+			got = append(got, string(s))
+		}
+
+		if !reflect.DeepEqual(got, c.rows) {
+			t.Errorf("Wrap(%q, %d) got %q want %q", c.data, c.width, got, c.rows)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	opts := Options{TabStop: 4, SGR: true}
+
+	cases := []struct {
+		data   string
+		column int
+		state  State
+	}{
+		{data: "abcdef", column: 2, state: State{Offset: 2, Column: 2, base: 'b'}},
+		{data: "abc", column: 10, state: State{Offset: 3, Column: 3, base: 'c'}},
+		// Wide characters straddling column are skipped.
+		{data: "a日本", column: 2, state: State{Offset: 4, Column: 3, base: '日'}},
+		// As are tabs.
+		{data: "a\tb", column: 2, state: State{Offset: 2, Column: 4}},
+		// Escape sequences before column set the style.
+		{data: "ab\x1b[31mcd", column: 2, state: State{Offset: 7, Column: 2, Style: Style{Fg: ColorRed}, base: 'b'}},
+		// Combining marks stay with the character before column.
+		{data: "ae\u0301c", column: 2, state: State{Offset: 4, Column: 2, base: 'é'}},
+	}
+
+	for _, c := range cases {
+		state := Skip([]byte(c.data), State{}, c.column, opts, false)
+		if state != c.state {
+			t.Errorf("Skip(%q, %d) got %+v want %+v", c.data, c.column, state, c.state)
+		}
+	}
+}
+
+// Skipping and laying out a line in pieces gives the same result as laying
+// it out all at once.
+func TestSkipChunks(t *testing.T) {
+	opts := Options{TabStop: 4, SGR: true}
+	data := []byte("a\tb日本\x1b[31mcde\u0301\u0323\x1b[0mfx\u0301ghijk")
+
+	want := Line(data, opts, false)
+
+	for column := 0; column < 20; column++ {
+		var state State
+		size := 3
+		for state.Column < column && state.Offset < len(data) {
+			end := state.Offset + size
+			if end > len(data) {
+				end = len(data)
+			}
+
+			next := Skip(data[state.Offset:end], state, column, opts, end < len(data))
+			if next == state {
+				// A sequence spans the chunk boundary.
+				size *= 2
+				continue
+			}
+			state = next
+		}
+
+		got := LineFrom(data[state.Offset:], state, opts, false)
+
+		// The cells from column onward should match.
+		var wantTail []Cell
+		var c int
+		for _, cell := range want {
+			if c >= state.Column {
+				wantTail = append(wantTail, cell)
+			}
+			c += cell.Width
+		}
+
+		if (len(got) > 0 || len(wantTail) > 0) && !reflect.DeepEqual(got, wantTail) {
+			t.Errorf("column %d: got %+v want %+v", column, got, wantTail)
+		}
+	}
+}
+
+// Counting rows, in one piece or several, gives the same number of rows as
+// Wrap.
+func TestCountRows(t *testing.T) {
+	opts := Options{TabStop: 4, SGR: true}
+
+	cases := []string{
+		"",
+		"abcd",
+		"abcdefghi",
+		"abc日本",
+		"a\tbcdefg\th",
+		"\x1b[31mabc\x1b[0mdéfghi",
+		"日本日本日本",
+	}
+
+	for _, data := range cases {
+		for width := 1; width <= 5; width++ {
+			want := len(Wrap(Line([]byte(data), opts, false), width))
+
+			if got := CountRows([]byte(data), Rows{}, width, opts, false).Count(); got != want {
+				t.Errorf("CountRows(%q, %d) got %d want %d", data, width, got, want)
+			}
+
+			// Count the rows two bytes at a time.
+			var rows Rows
+			for rows.Offset < len(data) {
+				end := rows.Offset + 2
+				if end > len(data) {
+					end = len(data)
+				}
+				next := CountRows([]byte(data[rows.Offset:end]), rows, width, opts, end < len(data))
+				if next.Offset == rows.Offset {
+					// A sequence spans the chunk boundary.
+					end = len(data)
+					next = CountRows([]byte(data[rows.Offset:end]), rows, width, opts, false)
+				}
+				rows = next
+			}
+			if got := rows.Count(); got != want {
+				t.Errorf("CountRows(%q, %d) in pieces got %d want %d", data, width, got, want)
+			}
+		}
+	}
+}
+
+// Laying out a line from the state returned by SkipRows gives the same rows
+// as laying out all of it.
+func TestSkipRows(t *testing.T) {
+	opts := Options{TabStop: 4, SGR: true}
+
+	cases := []string{
+		"abcdefghi",
+		"abc日本日本",
+		"a\tbcdefg\th",
+		"\x1b[31mabc\x1b[0mdéfghi",
+		"ab\x01cd\xe9efx́gh",
+	}
+
+	for _, data := range cases {
+		for width := 1; width <= 5; width++ {
+			want := Wrap(Line([]byte(data), opts, false), width)
+
+			for row := range want {
+				s, ok := SkipRows([]byte(data), Rows{}, row, width, opts, false)
+				first := s.Count() - 1
+				if !ok || first > row {
+					t.Errorf("SkipRows(%q, %d, %d) skipped to row %d", data, row, width, first)
+					continue
+				}
+
+				got := LineRows([]byte(data)[s.Offset:], s, width, opts, false)
+				if row-first >= len(got) || !reflect.DeepEqual(got[row-first], want[row]) {
+					t.Errorf("LineRows(%q, %d) from SkipRows to row %d got %+v want row %+v", data, width, row, got, want[row])
+				}
+			}
+		}
+	}
+}
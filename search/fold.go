@@ -0,0 +1,125 @@
+package search
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// foldFinder finds a literal string in data, ignoring case.
+type foldFinder struct {
+	data    []byte
+	literal []byte
+
+	// firsts are the first bytes of each case of the first character
+	// of literal.  Matches can only begin with one of them.
+	firsts []byte
+
+	// next is the index in data of the next of each of firsts, at or
+	// after the last index searched from, or len(data) if there is none.
+	next []int
+}
+
+// newFoldFinder returns a foldFinder for literal in data.  literal must not
+// be empty.
+func newFoldFinder(data, literal []byte) *foldFinder {
+	f := &foldFinder{data: data, literal: literal}
+
+	first, _ := utf8.DecodeRune(literal)
+	r := first
+	for {
+		var buf [utf8.UTFMax]byte
+		utf8.EncodeRune(buf[:], r)
+		if bytes.IndexByte(f.firsts, buf[0]) < 0 {
+			f.firsts = append(f.firsts, buf[0])
+			f.next = append(f.next, -1)
+		}
+
+		r = unicode.SimpleFold(r)
+		if r == first {
+			break
+		}
+	}
+
+	// An invalid first byte only matches itself.
+	if first == utf8.RuneError {
+		f.firsts = append(f.firsts[:0], literal[0])
+		f.next = f.next[:1]
+	}
+
+	return f
+}
+
+// candidate returns the index of the next of firsts at or after from, or
+// len(data) if there is none.
+func (f *foldFinder) candidate(from int) int {
+	min := len(f.data)
+	for k, c := range f.firsts {
+		if f.next[k] < from {
+			if i := bytes.IndexByte(f.data[from:], c); i >= 0 {
+				f.next[k] = from + i
+			} else {
+				f.next[k] = len(f.data)
+			}
+		}
+		if f.next[k] < min {
+			min = f.next[k]
+		}
+	}
+	return min
+}
+
+// index returns the index and length of the first match at or after from,
+// which ends by end, or -1 if there is none.
+func (f *foldFinder) index(from, end int) (int, int) {
+	for {
+		i := f.candidate(from)
+		if i >= end {
+			return -1, 0
+		}
+		if n := prefixFold(f.data[i:end], f.literal); n > 0 {
+			return i, n
+		}
+		from = i + 1
+	}
+}
+
+// prefixFold returns the length of the prefix of b that matches prefix,
+// ignoring case, or 0 if b doesn't begin with it.  Bytes that are not valid
+// UTF-8 only match themselves.
+func prefixFold(b, prefix []byte) int {
+	n := 0
+	for len(prefix) > 0 {
+		if n >= len(b) {
+			return 0
+		}
+
+		r, size := utf8.DecodeRune(b[n:])
+		p, psize := utf8.DecodeRune(prefix)
+		if r == utf8.RuneError || p == utf8.RuneError {
+			if b[n] != prefix[0] {
+				return 0
+			}
+			size, psize = 1, 1
+		} else if !equalFold(r, p) {
+			return 0
+		}
+
+		n += size
+		prefix = prefix[psize:]
+	}
+	return n
+}
+
+// equalFold returns true if r and p are the same character, ignoring case.
+func equalFold(r, p rune) bool {
+	if r == p {
+		return true
+	}
+	for f := unicode.SimpleFold(p); f != p; f = unicode.SimpleFold(f) {
+		if f == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,424 @@
+// Package search finds the lines of a file that match a regexp or contain a
+// literal string.  The file is
+// split into large chunks, aligned on line boundaries, which are searched in
+// parallel.
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+// DefaultChunkSize is the default number of bytes searched at once.
+const DefaultChunkSize = 1 << 20
+
+// Position is the start of a line.
+type Position struct {
+	// Line is the line number.
+	Line int64
+
+	// Offset is the byte offset of the start of the line.
+	Offset int64
+}
+
+// Match describes the matches in a single line.
+type Match struct {
+	// Line is the line number.
+	Line int64
+
+	// Matches are the byte offsets in the line of each match, like
+	// regexp.FindAllIndex.
+	Matches [][]int
+}
+
+// Searcher searches for a regexp or a literal string.  Each line is matched
+// separately, without its terminating newline, so matches never cross lines.
+type Searcher struct {
+	// Regexp is the pattern to search for.  It is ignored if Literal is
+	// set.
+	Regexp *regexp.Regexp
+
+	// Literal, if not empty, is the exact bytes to search for, rather
+	// than Regexp.
+	Literal []byte
+
+	// IgnoreCase makes Literal match text that differs from it only in
+	// case, as the (?i) regexp flag does.
+	IgnoreCase bool
+
+	// Filter, if not nil, transforms each chunk before it is searched,
+	// such as to remove escape sequences.  It returns the transformed
+	// chunk, and the offset in the original chunk of each byte of the
+	// transformed chunk, plus one extra offset for the end.  Newlines
+	// must be kept.  Match offsets are in the original chunk.
+	Filter func(b []byte) (filtered []byte, offsets []int)
+
+	// ChunkSize is the number of bytes searched at once.  If zero, it is
+	// DefaultChunkSize.
+	ChunkSize int
+
+	// Workers is the number of chunks searched in parallel.  If zero,
+	// it is runtime.GOMAXPROCS(0).
+	Workers int
+}
+
+// chunk is a part of the source, made up of whole lines.
+type chunk struct {
+	// index is the index of the chunk, counting from the start of the
+	// search.
+	index int
+
+	// offset is the offset of data in the source.
+	offset int64
+
+	// data is the contents of the chunk.
+	data []byte
+}
+
+// chunkResult is the result of searching a chunk.
+type chunkResult struct {
+	// index is the index of the chunk searched.
+	index int
+
+	// offset is the offset of the chunk in the source.
+	offset int64
+
+	// lines is the number of lines in the chunk.
+	lines int64
+
+	// last is the offset in the chunk of the start of the last line.
+	last int
+
+	// matches are the matching lines, numbered from 0 at the start of
+	// the chunk.
+	matches []Match
+}
+
+// Search searches the lines of src from start through the end of src.  It
+// calls found for each line with matches, in order.  After each chunk, it
+// calls progress with the last line searched so far, before which every line
+// has been searched.  found and progress may be nil.
+//
+// Search returns the start of the last line searched.  If src is growing,
+// that line may not have been complete, so a later search should begin
+// there.  If ctx is cancelled, Search stops early, returning ctx.Err().
+func (s *Searcher) Search(ctx context.Context, src io.ReaderAt, start Position, found func(Match), progress func(int64)) (Position, error) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	chunks := make(chan chunk, workers)
+	results := make(chan chunkResult, workers)
+
+	var readErr error
+	go func() {
+		readErr = s.readChunks(ctx, src, start.Offset, chunks)
+		close(chunks)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				// Just drain the remaining chunks once
+				// cancelled.
+				if ctx.Err() != nil {
+					continue
+				}
+				results <- s.searchChunk(c)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Chunks finish in any order, but are reported in order. pending
+	// holds results until every earlier chunk has been reported.
+	pending := make(map[int]chunkResult)
+	next := 0
+	last := start
+	line := start.Line
+	for r := range results {
+		pending[r.index] = r
+
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if ctx.Err() != nil {
+				continue
+			}
+
+			for _, m := range r.matches {
+				m.Line += line
+				if found != nil {
+					found(m)
+				}
+			}
+
+			line += r.lines
+			last = Position{
+				Line:   line - 1,
+				Offset: r.offset + int64(r.last),
+			}
+
+			if progress != nil {
+				progress(last.Line)
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return last, err
+	}
+
+	return last, readErr
+}
+
+// readChunks reads src from offset through the end, sending each chunk to
+// chunks.  Chunks end at the end of a line, so a long line may make a chunk
+// larger than ChunkSize.
+func (s *Searcher) readChunks(ctx context.Context, src io.ReaderAt, offset int64, chunks chan<- chunk) error {
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	for index := 0; ctx.Err() == nil; index++ {
+		size := chunkSize
+
+		var data []byte
+		for {
+			data = make([]byte, size)
+			n, err := src.ReadAt(data, offset)
+			data = data[:n]
+			if err == io.EOF {
+				// The rest of src, which may end partway
+				// through a line.
+				break
+			} else if err != nil {
+				return err
+			}
+
+			if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+				data = data[:i+1]
+				break
+			}
+
+			// The line continues beyond data.
+			size *= 2
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		chunks <- chunk{
+			index:  index,
+			offset: offset,
+			data:   data,
+		}
+
+		offset += int64(len(data))
+	}
+
+	return nil
+}
+
+// searchChunk finds the matching lines in c.
+func (s *Searcher) searchChunk(c chunk) chunkResult {
+	r := chunkResult{
+		index:  c.index,
+		offset: c.offset,
+		lines:  int64(bytes.Count(c.data, []byte{'\n'})),
+		last:   bytes.LastIndexByte(c.data[:len(c.data)-1], '\n') + 1,
+	}
+
+	// The last line may not end in a newline.
+	if c.data[len(c.data)-1] != '\n' {
+		r.lines++
+	}
+
+	data := c.data
+	var offsets []int
+	if s.Filter != nil {
+		data, offsets = s.Filter(c.data)
+	}
+
+	// match records the matches in the line at data[start:end], which is
+	// line number line in the chunk.
+	match := func(line int64, start, end int, matches [][]int) {
+		if len(matches) == 0 {
+			return
+		}
+
+		// Make the offsets relative to the start of the line, in the
+		// original chunk.
+		for _, m := range matches {
+			m[0] += start
+			m[1] += start
+		}
+		if offsets != nil {
+			origStart := 0
+			if start > 0 {
+				// The newline before the line is kept.
+				origStart = offsets[start-1] + 1
+			}
+			for _, m := range matches {
+				// Map the end to just after the last matched
+				// byte, rather than the next byte, which may
+				// follow a removed sequence.
+				if m[1] > m[0] {
+					m[1] = offsets[m[1]-1] + 1
+				} else {
+					m[1] = offsets[m[1]]
+				}
+				m[0] = offsets[m[0]]
+			}
+			start = origStart
+		}
+		for _, m := range matches {
+			m[0] -= start
+			m[1] -= start
+		}
+
+		r.matches = append(r.matches, Match{Line: line, Matches: matches})
+	}
+
+	switch {
+	case len(s.Literal) > 0 && s.IgnoreCase:
+		s.searchFold(data, match)
+		return r
+	case len(s.Literal) > 0:
+		s.searchPrefix(data, s.Literal, true, match)
+		return r
+	}
+
+	// LiteralPrefix claims anchored patterns like ^foo$ are complete,
+	// so only trust it for patterns with nothing but the literal.
+	prefix, _ := s.Regexp.LiteralPrefix()
+	complete := regexp.QuoteMeta(prefix) == s.Regexp.String()
+	if prefix == "" {
+		s.searchLines(data, match)
+	} else {
+		s.searchPrefix(data, []byte(prefix), complete, match)
+	}
+
+	return r
+}
+
+// lineEnd returns the index of the newline ending the line containing i in
+// data, or len(data) if the line doesn't end in a newline.
+func lineEnd(data []byte, i int) int {
+	if n := bytes.IndexByte(data[i:], '\n'); n >= 0 {
+		return i + n
+	}
+	return len(data)
+}
+
+// searchLines matches every line in data against the regexp.
+func (s *Searcher) searchLines(data []byte, match func(line int64, start, end int, matches [][]int)) {
+	var line int64
+	for start := 0; start < len(data); line++ {
+		end := lineEnd(data, start)
+		match(line, start, end, s.Regexp.FindAllIndex(data[start:end], -1))
+		start = end + 1
+	}
+}
+
+// searchIndexed matches the lines in data found by index, which returns the
+// index of the first possible match at or after from, or -1 if there is
+// none.  lineMatches returns the matches in the line at data[start:end], in
+// which i is the possible match.
+func searchIndexed(data []byte, index func(from int) int, lineMatches func(start, end, i int) [][]int, match func(line int64, start, end int, matches [][]int)) {
+	// line is the number of the line starting at start.
+	var line int64
+	start := 0
+
+	for start < len(data) {
+		i := index(start)
+		if i < 0 {
+			return
+		}
+
+		// Move to the line containing the match.
+		lineStart := bytes.LastIndexByte(data[start:i], '\n') + 1 + start
+		line += int64(bytes.Count(data[start:lineStart], []byte{'\n'}))
+		start = lineStart
+		end := lineEnd(data, i)
+
+		match(line, start, end, lineMatches(start, end, i))
+
+		line++
+		start = end + 1
+	}
+}
+
+// searchPrefix matches the lines in data that contain prefix, which begins
+// every match of the regexp, against the regexp.  bytes.Index is much faster
+// than the regexp at skipping lines that can't match.  If complete is true,
+// the regexp is just prefix, so it is not needed at all.
+func (s *Searcher) searchPrefix(data, prefix []byte, complete bool, match func(line int64, start, end int, matches [][]int)) {
+	index := func(from int) int {
+		if i := bytes.Index(data[from:], prefix); i >= 0 {
+			return from + i
+		}
+		return -1
+	}
+
+	lineMatches := func(start, end, i int) [][]int {
+		if !complete {
+			return s.Regexp.FindAllIndex(data[start:end], -1)
+		}
+
+		var matches [][]int
+		b := data[start:end]
+		for j := i - start; j <= len(b)-len(prefix); {
+			k := bytes.Index(b[j:], prefix)
+			if k < 0 {
+				break
+			}
+			matches = append(matches, []int{j + k, j + k + len(prefix)})
+			j += k + len(prefix)
+		}
+		return matches
+	}
+
+	searchIndexed(data, index, lineMatches, match)
+}
+
+// searchFold matches the lines in data that contain Literal, ignoring case.
+func (s *Searcher) searchFold(data []byte, match func(line int64, start, end int, matches [][]int)) {
+	f := newFoldFinder(data, s.Literal)
+
+	index := func(from int) int {
+		i, _ := f.index(from, len(data))
+		return i
+	}
+
+	lineMatches := func(start, end, i int) [][]int {
+		var matches [][]int
+		for i >= 0 {
+			_, n := f.index(i, end)
+			matches = append(matches, []int{i - start, i + n - start})
+			i, _ = f.index(i+n, end)
+		}
+		return matches
+	}
+
+	searchIndexed(data, index, lineMatches, match)
+}
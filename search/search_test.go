@@ -0,0 +1,307 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prattmic/lesser/lineio"
+)
+
+// reference finds the matches in data one line at a time.
+func reference(data string, reg *regexp.Regexp) []Match {
+	if data == "" {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+
+	var matches []Match
+	for i, line := range lines {
+		if m := reg.FindAllStringIndex(line, -1); len(m) > 0 {
+			matches = append(matches, Match{Line: int64(i + 1), Matches: m})
+		}
+	}
+	return matches
+}
+
+// search runs s over data from the start, returning the matches.
+func search(t *testing.T, s *Searcher, data string) []Match {
+	var matches []Match
+	_, err := s.Search(context.Background(), strings.NewReader(data), Position{Line: 1}, func(m Match) {
+		matches = append(matches, m)
+	}, nil)
+	if err != nil {
+		t.Errorf("Search(%v, %q) got err %v", s.Regexp, data, err)
+	}
+	return matches
+}
+
+func TestSearch(t *testing.T) {
+	data := []string{
+		"",
+		"foo",
+		"foo\n",
+		"foo\nbar\nbaz foo foo\n\nfoobar\nbarfoo",
+		"aaa\nfoo bar\n" + strings.Repeat("x", 100) + "foo\n\n\nFOO\n",
+		strings.Repeat("line foo\nno match here\nfoofoofoo\n", 50),
+	}
+
+	patterns := []string{
+		`foo`,
+		`^foo$`,
+		`o+`,
+		`f.o`,
+		`(?i)foo`,
+		`foo|bar`,
+		`foo.*bar`,
+		`^$`,
+		`x*`,
+		``,
+		`foo\nbar`,
+	}
+
+	for _, d := range data {
+		for _, p := range patterns {
+			reg := regexp.MustCompile(p)
+			want := reference(d, reg)
+
+			for _, chunkSize := range []int{1, 3, 16, 0} {
+				for _, workers := range []int{1, 4} {
+					s := &Searcher{Regexp: reg, ChunkSize: chunkSize, Workers: workers}
+					if got := search(t, s, d); !reflect.DeepEqual(got, want) {
+						t.Errorf("Search(%q, %q) with chunk size %d, %d workers got %v want %v", p, d, chunkSize, workers, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSearchStart(t *testing.T) {
+	data := "foo\nbar\nfoo\nfoo bar"
+	reg := regexp.MustCompile(`bar`)
+
+	var matches []Match
+	var progress []int64
+	s := &Searcher{Regexp: reg, ChunkSize: 4, Workers: 2}
+	last, err := s.Search(context.Background(), strings.NewReader(data), Position{Line: 3, Offset: 8}, func(m Match) {
+		matches = append(matches, m)
+	}, func(line int64) {
+		progress = append(progress, line)
+	})
+	if err != nil {
+		t.Fatalf("Search got err %v", err)
+	}
+
+	if want := []Match{{Line: 4, Matches: [][]int{{4, 7}}}}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("Search got matches %v want %v", matches, want)
+	}
+	if want := []int64{3, 4}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("Search got progress %v want %v", progress, want)
+	}
+	if want := (Position{Line: 4, Offset: 12}); last != want {
+		t.Errorf("Search returned %+v want %+v", last, want)
+	}
+}
+
+func TestSearchLiteral(t *testing.T) {
+	data := "a.b\naxb a.b a.b\n\n(a.b)\nA.B\n"
+
+	for _, lit := range []string{`a.b`, `.`, `(a.b)`, `\n`} {
+		want := reference(data, regexp.MustCompile(regexp.QuoteMeta(lit)))
+
+		for _, chunkSize := range []int{1, 5, 0} {
+			s := &Searcher{Literal: []byte(lit), ChunkSize: chunkSize}
+			if got := search(t, s, data); !reflect.DeepEqual(got, want) {
+				t.Errorf("Search(literal %q) with chunk size %d got %v want %v", lit, chunkSize, got, want)
+			}
+		}
+	}
+}
+
+func TestSearchLiteralFold(t *testing.T) {
+	// The Kelvin sign folds to k, and ſ to s, though they are longer.
+	data := "a.b\nA.B a.B\n\nKiss \u212aiſſ kISS\nΣίσυφος ΣΊΣΥΦΟΣ\nnone\n\xffx\xffX\n"
+
+	for _, lit := range []string{`a.b`, `A.b`, `kiss`, `σίσυφος`, `ς`, `x`} {
+		want := reference(data, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(lit)))
+
+		for _, chunkSize := range []int{1, 5, 0} {
+			s := &Searcher{Literal: []byte(lit), IgnoreCase: true, ChunkSize: chunkSize}
+			if got := search(t, s, data); !reflect.DeepEqual(got, want) {
+				t.Errorf("Search(literal %q ignoring case) with chunk size %d got %v want %v", lit, chunkSize, got, want)
+			}
+		}
+	}
+}
+
+// stripX is a Searcher Filter that removes all 'X' bytes.
+func stripX(b []byte) ([]byte, []int) {
+	var stripped []byte
+	var offsets []int
+	for i, c := range b {
+		if c != 'X' {
+			stripped = append(stripped, c)
+			offsets = append(offsets, i)
+		}
+	}
+	return stripped, append(offsets, len(b))
+}
+
+func TestSearchFilter(t *testing.T) {
+	data := "XfoXo\nbar fXoo\nfoX\n"
+
+	for _, p := range []string{`foo`, `f.o`} {
+		s := &Searcher{Regexp: regexp.MustCompile(p), Filter: stripX, ChunkSize: 8}
+
+		want := []Match{
+			{Line: 1, Matches: [][]int{{1, 5}}},
+			{Line: 2, Matches: [][]int{{4, 8}}},
+		}
+		if got := search(t, s, data); !reflect.DeepEqual(got, want) {
+			t.Errorf("Search(%q) got %v want %v", p, got, want)
+		}
+	}
+}
+
+func TestSearchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := &Searcher{Regexp: regexp.MustCompile(`foo`)}
+	_, err := s.Search(ctx, strings.NewReader(strings.Repeat("foo\n", 1000)), Position{Line: 1}, func(m Match) {
+		t.Errorf("Search found %v after cancel", m)
+	}, nil)
+	if err != context.Canceled {
+		t.Errorf("Search got err %v want %v", err, context.Canceled)
+	}
+}
+
+// benchSize is the size of the input to benchmarks. Searching is most
+// interesting on very large files, so try e.g. -search.size=1073741824.
+var benchSize = flag.Int("search.size", 64<<20, "Size in bytes of the input to benchmarks")
+
+var benchInput []byte
+
+// input returns the input to benchmarks: log-like lines, a few of which
+// contain "needle".
+func input() []byte {
+	if len(benchInput) == *benchSize {
+		return benchInput
+	}
+
+	var b bytes.Buffer
+	b.Grow(*benchSize + 100)
+	for i := 0; b.Len() < *benchSize; i++ {
+		fmt.Fprintf(&b, "2006-01-02 15:04:05.%06d INFO request %d handled in %dms", i%1000000, i, i%997)
+		if i%10007 == 0 {
+			b.WriteString(" needle")
+		}
+		b.WriteByte('\n')
+	}
+	benchInput = b.Bytes()[:*benchSize]
+	return benchInput
+}
+
+var benchPatterns = []struct {
+	name    string
+	pattern string
+}{
+	{name: "Literal", pattern: `needle`},
+	{name: "Prefix", pattern: `request \d+ handled in 99\dms needle`},
+	{name: "Regexp", pattern: `[a-z]+ \d+ handled in 99\dms`},
+}
+
+func BenchmarkSearch(b *testing.B) {
+	data := input()
+
+	for _, p := range benchPatterns {
+		b.Run(p.name, func(b *testing.B) {
+			s := &Searcher{Regexp: regexp.MustCompile(p.pattern)}
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				s.Search(context.Background(), bytes.NewReader(data), Position{Line: 1}, nil, nil)
+			}
+		})
+	}
+}
+
+// BenchmarkSearchFold compares searching for a literal ignoring case with
+// the equivalent regexp.
+func BenchmarkSearchFold(b *testing.B) {
+	data := input()
+
+	searchers := []struct {
+		name string
+		s    *Searcher
+	}{
+		{name: "Literal", s: &Searcher{Literal: []byte("needle"), IgnoreCase: true}},
+		{name: "Regexp", s: &Searcher{Regexp: regexp.MustCompile(`(?i)needle`)}},
+	}
+
+	for _, c := range searchers {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				c.s.Search(context.Background(), bytes.NewReader(data), Position{Line: 1}, nil, nil)
+			}
+		})
+	}
+}
+
+// BenchmarkSearchPerLine measures the search this package replaces, which
+// searched each line in its own goroutine, with a few lines in flight.
+func BenchmarkSearchPerLine(b *testing.B) {
+	data := input()
+
+	for _, p := range benchPatterns {
+		b.Run(p.name, func(b *testing.B) {
+			reg := regexp.MustCompile(p.pattern)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				perLine(lineio.NewLineReader(bytes.NewReader(data)), reg)
+			}
+		})
+	}
+}
+
+// perLine searches every line in src for reg, searching each line in a
+// separate goroutine.
+func perLine(src *lineio.LineReader, reg *regexp.Regexp) {
+	type result struct {
+		matches [][]int
+		err     error
+	}
+	results := make(chan result, 100)
+
+	searchLine := func(line int64) {
+		m, err := src.SearchLine(reg, line)
+		results <- result{matches: m, err: err}
+	}
+
+	var next, count int64 = 1, 0
+	for ; next <= 5; next++ {
+		go searchLine(next)
+	}
+
+	for {
+		r := <-results
+		count++
+		if r.err != nil {
+			break
+		}
+		go searchLine(next)
+		next++
+	}
+
+	for count < next-1 {
+		<-results
+		count++
+	}
+}
@@ -75,8 +75,12 @@ func (m *Map) Insert(k, v int64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Delete any duplicate entry.
-	m.deleteImpl(k)
+	// An existing key just needs the new value. Deleting it first
+	// would move the keys after it twice.
+	if _, ok := m.m[k]; ok {
+		m.m[k] = v
+		return
+	}
 
 	m.m[k] = v
 	m.k.Insert(k)
@@ -150,6 +154,23 @@ func (m *Map) NearestGreater(want int64) (key, value int64, err error) {
 	return key, value, nil
 }
 
+// Len returns the number of keys in the map.
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.k)
+}
+
+// Rank returns the number of keys in the map less than k.
+func (m *Map) Rank(k int64) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, _ := m.k.Search(k)
+	return i
+}
+
 func NewMap() Map {
 	return Map{
 		m: make(map[int64]int64),
@@ -45,6 +45,21 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestInsertExisting(t *testing.T) {
+	m := NewMap()
+	m.Insert(1, 2)
+	m.Insert(3, 4)
+	m.Insert(1, 5)
+
+	if v, ok := m.Get(1); !ok || v != 5 {
+		t.Errorf("Get(1) got %d, %v want 5, true", v, ok)
+	}
+
+	if len(m.k) != 2 || m.k[0] != 1 || m.k[1] != 3 {
+		t.Errorf("Got keys %v, expected [1 3]", m.k)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	cases := []struct {
 		before Map
@@ -75,7 +90,8 @@ func TestDelete(t *testing.T) {
 		},
 	}
 
-	for _, c := range cases {
+	for i := range cases {
+		c := &cases[i]
 		for _, k := range c.del {
 			c.before.Delete(k)
 		}
@@ -185,7 +201,7 @@ func TestNearestGreaterEqual(t *testing.T) {
 	// Nothing bigger than biggest
 	_, _, err := m.NearestGreater(5)
 	if err != ErrNoSuchKey {
-		t.Errorf("want ErrNoSuchKey got %v for NG(5)")
+		t.Errorf("want ErrNoSuchKey got %v for NG(5)", err)
 	}
 
 	// One below
@@ -212,3 +228,31 @@ func TestNearestGreaterEqual(t *testing.T) {
 		t.Errorf("bad value for NG(-1000): want 20 got %d", k)
 	}
 }
+
+func TestRank(t *testing.T) {
+	m := Map{
+		m: map[int64]int64{2: 20, 4: 40},
+		k: sortedSlice{2, 4},
+	}
+
+	if l := m.Len(); l != 2 {
+		t.Errorf("want 2 got %d for Len()", l)
+	}
+
+	cases := []struct {
+		key  int64
+		rank int
+	}{
+		{key: 1, rank: 0},
+		{key: 2, rank: 0},
+		{key: 3, rank: 1},
+		{key: 4, rank: 1},
+		{key: 5, rank: 2},
+	}
+
+	for _, c := range cases {
+		if r := m.Rank(c.key); r != c.rank {
+			t.Errorf("want %d got %d for Rank(%d)", c.rank, r, c.key)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+	"syscall"
+
+	"github.com/prattmic/lesser/decompress"
+	"github.com/prattmic/lesser/spool"
+)
+
+var ErrNegativeOffset = errors.New("Negative offset.")
+
+// SourceChange describes how a Source changed.
+type SourceChange int
+
+const (
+	// SourceUnchanged means the contents have not changed.
+	SourceUnchanged SourceChange = iota
+
+	// SourceGrew means data was appended. All existing data is unchanged.
+	SourceGrew
+
+	// SourceTruncated means the file shrank. All existing data may have
+	// changed.
+	SourceTruncated
+
+	// SourceReplaced means the file was replaced by a new file at the
+	// same path, which has been reopened. All existing data may have
+	// changed.
+	SourceReplaced
+)
+
+// Source is the underlying data displayed by Lesser.
+type Source interface {
+	io.ReaderAt
+	io.Closer
+
+	// Update checks for new data, returning how the contents have
+	// changed since the last call to Update.
+	Update() (SourceChange, error)
+
+	// Streaming returns true if more data may still arrive in the
+	// background.
+	Streaming() bool
+
+	// Size returns the number of bytes available.
+	Size() int64
+}
+
+// mmapSource is a regular file, mapped into memory.  If the file grows or
+// shrinks, it is remapped to match.  If the path is replaced by a new file,
+// as is common with log rotation, the new file is opened.
+type mmapSource struct {
+	// name is the path of the file.
+	name string
+
+	// f is the open file.  Only accessed by Update and Close.
+	f *os.File
+
+	// mu locks m.
+	mu sync.RWMutex
+
+	// m is the mapped file.  It is nil if the file was empty when
+	// last mapped.
+	m []byte
+}
+
+// mmapFile maps the first size bytes of f.
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	// Empty mappings are not allowed.
+	if size == 0 {
+		return nil, nil
+	}
+
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_PRIVATE)
+}
+
+func (m *mmapSource) ReadAt(p []byte, off int64) (n int, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// If the file is truncated, accessing the mapping beyond the new end
+	// of the file raises SIGBUS.  Recover and report EOF until Update
+	// notices and remaps the file.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = 0, io.EOF
+		}
+	}()
+
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+
+	if off >= int64(len(m.m)) {
+		return 0, io.EOF
+	}
+
+	n = copy(p, m.m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (m *mmapSource) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.m != nil {
+		syscall.Munmap(m.m)
+		m.m = nil
+	}
+
+	return m.f.Close()
+}
+
+// remap replaces the mapping with one of size bytes.
+func (m *mmapSource) remap(size int64) error {
+	n, err := mmapFile(m.f, size)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	old := m.m
+	m.m = n
+	m.mu.Unlock()
+
+	if old != nil {
+		syscall.Munmap(old)
+	}
+
+	return nil
+}
+
+// reopen opens the file at name, replacing the current file.
+func (m *mmapSource) reopen() error {
+	f, err := os.Open(m.name)
+	if err != nil {
+		return err
+	}
+
+	old := m.f
+	m.f = f
+
+	stat, err := f.Stat()
+	if err == nil {
+		err = m.remap(stat.Size())
+	}
+	if err != nil {
+		// Stick with the old file.
+		m.f = old
+		f.Close()
+		return err
+	}
+
+	old.Close()
+
+	return nil
+}
+
+// Update remaps the file if it has changed size, or reopens it if the path
+// now refers to a different file.
+func (m *mmapSource) Update() (SourceChange, error) {
+	stat, err := m.f.Stat()
+	if err != nil {
+		return SourceUnchanged, err
+	}
+
+	// If the path no longer exists, it may be mid-rotation. Keep
+	// displaying the old file until a new one appears.
+	if cur, err := os.Stat(m.name); err == nil && !os.SameFile(stat, cur) {
+		if err := m.reopen(); err != nil {
+			return SourceUnchanged, err
+		}
+		return SourceReplaced, nil
+	}
+
+	m.mu.RLock()
+	size := int64(len(m.m))
+	m.mu.RUnlock()
+
+	var change SourceChange
+	switch {
+	case stat.Size() > size:
+		change = SourceGrew
+	case stat.Size() < size:
+		change = SourceTruncated
+	default:
+		return SourceUnchanged, nil
+	}
+
+	if err := m.remap(stat.Size()); err != nil {
+		return SourceUnchanged, err
+	}
+
+	return change, nil
+}
+
+func (m *mmapSource) Streaming() bool {
+	return false
+}
+
+func (m *mmapSource) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return int64(len(m.m))
+}
+
+// spoolSource is a non-seekable stream, such as a pipe or a decompressed
+// file, spooled into memory as it arrives.
+type spoolSource struct {
+	*spool.Spool
+
+	// closers are closed when the source is closed.
+	closers []io.Closer
+
+	// lastSize is the spool size at the last call to Update.
+	lastSize int64
+
+	// reported is true once Update has returned the stream error.
+	reported bool
+}
+
+func (s *spoolSource) Close() error {
+	var err error
+	for _, c := range s.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Update reports whether more data has been spooled since the last call.
+// If the stream ended with an error, it is returned once.
+func (s *spoolSource) Update() (SourceChange, error) {
+	size := s.Size()
+	change := SourceUnchanged
+	if size != s.lastSize {
+		change = SourceGrew
+	}
+	s.lastSize = size
+
+	err := s.Err()
+	if err == nil || s.reported {
+		return change, nil
+	}
+	s.reported = true
+
+	return change, err
+}
+
+func (s *spoolSource) Streaming() bool {
+	return !s.Done()
+}
+
+// newSpoolSource spools r, decompressing it if it is compressed.
+func newSpoolSource(r io.ReadCloser) *spoolSource {
+	d := decompress.NewAutoReader(r)
+
+	return &spoolSource{
+		Spool:   spool.NewSpool(d),
+		closers: []io.Closer{d, r},
+	}
+}
+
+// OpenSource opens the named file.  Regular files are mapped directly into
+// memory, while anything else (pipes, character devices, etc) is spooled.
+// Compressed files are decompressed and spooled.
+func OpenSource(name string) (Source, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if !stat.Mode().IsRegular() {
+		return newSpoolSource(f), nil
+	}
+
+	header := make([]byte, decompress.HeaderSize)
+	n, _ := f.ReadAt(header, 0)
+	if decompress.Detect(header[:n]) != decompress.None {
+		return newSpoolSource(f), nil
+	}
+
+	m, err := mmapFile(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &mmapSource{
+		name: name,
+		f:    f,
+		m:    m,
+	}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openMmap opens the file at path, which must be mapped.
+func openMmap(t *testing.T, path string) *mmapSource {
+	src, err := OpenSource(path)
+	if err != nil {
+		t.Fatalf("OpenSource(%q) got err %v", path, err)
+	}
+
+	m, ok := src.(*mmapSource)
+	if !ok {
+		src.Close()
+		t.Fatalf("OpenSource(%q) got %T want *mmapSource", path, src)
+	}
+	return m
+}
+
+// readAll returns the contents of src.
+func readAll(t *testing.T, src Source) string {
+	buf := make([]byte, src.Size())
+	n, err := src.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadAt(0) got err %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestMmapSourceUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatalf("TempDir got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	page := os.Getpagesize()
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", 4*page)), 0600); err != nil {
+		t.Fatalf("WriteFile got err %v", err)
+	}
+
+	m := openMmap(t, path)
+	defer m.Close()
+
+	if change, err := m.Update(); change != SourceUnchanged || err != nil {
+		t.Errorf("Update got %v, %v want %v", change, err, SourceUnchanged)
+	}
+
+	// The mapping extends beyond the end of the truncated file, so
+	// reading there faults until Update remaps it.
+	if err := os.Truncate(path, 10); err != nil {
+		t.Fatalf("Truncate got err %v", err)
+	}
+	buf := make([]byte, 10)
+	if n, err := m.ReadAt(buf, int64(2*page)); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(%d) after truncate got %d, %v want 0, %v", 2*page, n, err, io.EOF)
+	}
+
+	if change, err := m.Update(); change != SourceTruncated || err != nil {
+		t.Errorf("Update got %v, %v want %v", change, err, SourceTruncated)
+	}
+	if got, want := readAll(t, m), strings.Repeat("x", 10); got != want {
+		t.Errorf("contents got %q want %q", got, want)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile got err %v", err)
+	}
+	_, err = f.WriteString("y")
+	f.Close()
+	if err != nil {
+		t.Fatalf("WriteString got err %v", err)
+	}
+
+	if change, err := m.Update(); change != SourceGrew || err != nil {
+		t.Errorf("Update got %v, %v want %v", change, err, SourceGrew)
+	}
+	if got, want := readAll(t, m), strings.Repeat("x", 10)+"y"; got != want {
+		t.Errorf("contents got %q want %q", got, want)
+	}
+}
+
+func TestMmapSourceReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatalf("TempDir got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatalf("WriteFile got err %v", err)
+	}
+
+	m := openMmap(t, path)
+	defer m.Close()
+
+	// While the path is missing, mid-rotation, the old file is kept.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Rename got err %v", err)
+	}
+	if change, err := m.Update(); change != SourceUnchanged || err != nil {
+		t.Errorf("Update with path missing got %v, %v want %v", change, err, SourceUnchanged)
+	}
+	if got := readAll(t, m); got != "old" {
+		t.Errorf("contents with path missing got %q want %q", got, "old")
+	}
+
+	// Then the new file at the path is opened.
+	if err := ioutil.WriteFile(path, []byte("recreated"), 0600); err != nil {
+		t.Fatalf("WriteFile got err %v", err)
+	}
+	if change, err := m.Update(); change != SourceReplaced || err != nil {
+		t.Errorf("Update after recreate got %v, %v want %v", change, err, SourceReplaced)
+	}
+	if got := readAll(t, m); got != "recreated" {
+		t.Errorf("contents after recreate got %q want %q", got, "recreated")
+	}
+
+	// As is a file renamed over the path.
+	if err := ioutil.WriteFile(path+".new", []byte("renamed"), 0600); err != nil {
+		t.Fatalf("WriteFile got err %v", err)
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		t.Fatalf("Rename got err %v", err)
+	}
+	if change, err := m.Update(); change != SourceReplaced || err != nil {
+		t.Errorf("Update after rename got %v, %v want %v", change, err, SourceReplaced)
+	}
+	if got := readAll(t, m); got != "renamed" {
+		t.Errorf("contents after rename got %q want %q", got, "renamed")
+	}
+
+	if change, err := m.Update(); change != SourceUnchanged || err != nil {
+		t.Errorf("Update got %v, %v want %v", change, err, SourceUnchanged)
+	}
+}
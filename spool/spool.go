@@ -0,0 +1,135 @@
+// Package spool provides random access to data from a non-seekable stream.
+package spool
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrNegativeOffset = errors.New("Negative offset.")
+
+// chunkSize is the size of each buffer used to store spooled data.
+// Data is stored in many chunks, rather than one large slice, to
+// avoid copying everything received so far each time the buffer grows.
+const chunkSize = 1 << 20
+
+// Spool buffers an io.Reader in memory in the background, allowing random
+// access to the data that has arrived so far.
+type Spool struct {
+	// src is the stream being spooled.  It is only accessed by the
+	// spooling goroutine.
+	src io.Reader
+
+	// mu locks the fields below.
+	mu sync.RWMutex
+
+	// chunks contains the data received so far.  All chunks except the
+	// last are full.
+	chunks [][]byte
+
+	// size is the total number of bytes received.
+	size int64
+
+	// done is true once src has returned an error, including io.EOF.
+	done bool
+
+	// err is the error that ended the stream, if it was not io.EOF.
+	err error
+}
+
+// spool reads src until it returns an error.
+func (s *Spool) spool() {
+	for {
+		s.mu.Lock()
+		last := len(s.chunks) - 1
+		if last < 0 || len(s.chunks[last]) == chunkSize {
+			s.chunks = append(s.chunks, make([]byte, 0, chunkSize))
+			last++
+		}
+		buf := s.chunks[last]
+		s.mu.Unlock()
+
+		// Bytes beyond size are never touched by readers, so we may
+		// safely read into them without holding mu.
+		n, err := s.src.Read(buf[len(buf):cap(buf)])
+
+		s.mu.Lock()
+		s.chunks[last] = buf[:len(buf)+n]
+		s.size += int64(n)
+		if err != nil {
+			s.done = true
+			if err != io.EOF {
+				s.err = err
+			}
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ReadAt reads len(p) bytes at offset off from the data received so far.
+// If less than len(p) bytes are available, it returns io.EOF, even if more
+// data may arrive later.
+func (s *Spool) ReadAt(p []byte, off int64) (n int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+
+	for n < len(p) && off < s.size {
+		c := s.chunks[off/chunkSize]
+		i := off % chunkSize
+
+		copied := copy(p[n:], c[i:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Size returns the number of bytes received so far.
+func (s *Spool) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.size
+}
+
+// Done returns true once the stream has ended, and no more data will arrive.
+func (s *Spool) Done() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.done
+}
+
+// Err returns the error that ended the stream, if any.  A stream that ended
+// with io.EOF has no error.
+func (s *Spool) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.err
+}
+
+// NewSpool starts spooling r in the background.
+func NewSpool(r io.Reader) *Spool {
+	s := &Spool{
+		src: r,
+	}
+
+	go s.spool()
+
+	return s
+}
@@ -0,0 +1,138 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// waitDone waits for s to finish spooling.
+func waitDone(t *testing.T, s *Spool) {
+	for i := 0; i < 1000; i++ {
+		if s.Done() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("spool never finished")
+}
+
+// waitSize waits for s to receive at least size bytes.
+func waitSize(t *testing.T, s *Spool, size int64) {
+	for i := 0; i < 1000; i++ {
+		if s.Size() >= size {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("spool never reached size %d, got %d", size, s.Size())
+}
+
+func TestReadAt(t *testing.T) {
+	s := NewSpool(bytes.NewReader([]byte("Hello World!")))
+	waitDone(t, s)
+
+	cases := []struct {
+		off     int64
+		bufSize int
+		data    string
+		err     error
+	}{
+		{off: 0, bufSize: 5, data: "Hello", err: nil},
+		{off: 6, bufSize: 6, data: "World!", err: nil},
+		{off: 6, bufSize: 128, data: "World!", err: io.EOF},
+		{off: 12, bufSize: 1, data: "", err: io.EOF},
+		{off: 100, bufSize: 1, data: "", err: io.EOF},
+		{off: -1, bufSize: 1, data: "", err: ErrNegativeOffset},
+	}
+
+	for _, c := range cases {
+		buf := make([]byte, c.bufSize)
+
+		n, err := s.ReadAt(buf, c.off)
+		if err != c.err {
+			t.Errorf("ReadAt(%d, %d): err got %v want %v", c.bufSize, c.off, err, c.err)
+		}
+
+		if string(buf[:n]) != c.data {
+			t.Errorf("ReadAt(%d, %d): got '%s' want '%s'", c.bufSize, c.off, buf[:n], c.data)
+		}
+	}
+}
+
+// Reads must work across chunk boundaries.
+func TestReadAtChunks(t *testing.T) {
+	data := make([]byte, 3*chunkSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	s := NewSpool(bytes.NewReader(data))
+	waitDone(t, s)
+
+	if s.Size() != int64(len(data)) {
+		t.Errorf("Size() got %d want %d", s.Size(), len(data))
+	}
+
+	buf := make([]byte, chunkSize+20)
+	off := int64(chunkSize - 10)
+
+	n, err := s.ReadAt(buf, off)
+	if err != nil {
+		t.Errorf("ReadAt(%d, %d): err got %v want nil", len(buf), off, err)
+	}
+
+	if !bytes.Equal(buf[:n], data[off:off+int64(len(buf))]) {
+		t.Errorf("ReadAt(%d, %d): wrong data", len(buf), off)
+	}
+}
+
+// Data is available as it arrives, before the stream ends.
+func TestStreaming(t *testing.T) {
+	r, w := io.Pipe()
+	s := NewSpool(r)
+
+	w.Write([]byte("Line 1\n"))
+	waitSize(t, s, 7)
+
+	if s.Done() {
+		t.Errorf("Done() got true want false")
+	}
+
+	buf := make([]byte, 128)
+	n, err := s.ReadAt(buf, 0)
+	if err != io.EOF {
+		t.Errorf("ReadAt: err got %v want %v", err, io.EOF)
+	}
+	if string(buf[:n]) != "Line 1\n" {
+		t.Errorf("ReadAt: got '%s' want 'Line 1\\n'", buf[:n])
+	}
+
+	w.Write([]byte("Line 2\n"))
+	w.Close()
+	waitDone(t, s)
+
+	n, err = s.ReadAt(buf, 0)
+	if string(buf[:n]) != "Line 1\nLine 2\n" {
+		t.Errorf("ReadAt: got '%s' want 'Line 1\\nLine 2\\n'", buf[:n])
+	}
+
+	if s.Err() != nil {
+		t.Errorf("Err() got %v want nil", s.Err())
+	}
+}
+
+func TestErr(t *testing.T) {
+	r, w := io.Pipe()
+	s := NewSpool(r)
+
+	want := errors.New("broken")
+	w.CloseWithError(want)
+	waitDone(t, s)
+
+	if s.Err() != want {
+		t.Errorf("Err() got %v want %v", s.Err(), want)
+	}
+}
@@ -0,0 +1,81 @@
+// Package state reads and writes files kept between sessions, such as the
+// search history, in the user's state directory.
+package state
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Path returns the path of the state file called name,
+// $XDG_STATE_HOME/lesser/name, or ~/.local/state/lesser/name if
+// XDG_STATE_HOME is not set.
+func Path(name string) (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(dir, "lesser", name), nil
+}
+
+// ReadLines returns the lines in the file at path.  A file that doesn't exist
+// has no lines.
+func ReadLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+
+	return lines, s.Err()
+}
+
+// WriteLines replaces the file at path with lines, creating its directory if
+// needed.  The file is replaced atomically, so other sessions never read it
+// partially written.
+func WriteLines(path string, lines []string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,71 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	old, ok := os.LookupEnv("XDG_STATE_HOME")
+	defer func() {
+		if ok {
+			os.Setenv("XDG_STATE_HOME", old)
+		} else {
+			os.Unsetenv("XDG_STATE_HOME")
+		}
+	}()
+
+	os.Setenv("XDG_STATE_HOME", "/state")
+	path, err := Path("history")
+	if err != nil {
+		t.Fatalf("Path got err %v", err)
+	}
+	if want := "/state/lesser/history"; path != want {
+		t.Errorf("Path got %q want %q", path, want)
+	}
+}
+
+func TestReadWriteLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("TempDir got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lesser", "history")
+
+	// A missing file has no lines.
+	lines, err := ReadLines(path)
+	if err != nil {
+		t.Fatalf("ReadLines got err %v", err)
+	}
+	if len(lines) != 0 {
+		t.Errorf("ReadLines got %q from missing file, want none", lines)
+	}
+
+	for _, want := range [][]string{{"a", "b c"}, {"d"}, nil} {
+		if err := WriteLines(path, want); err != nil {
+			t.Fatalf("WriteLines(%q) got err %v", want, err)
+		}
+
+		lines, err := ReadLines(path)
+		if err != nil {
+			t.Fatalf("ReadLines got err %v", err)
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("ReadLines after WriteLines(%q) got %q", want, lines)
+		}
+	}
+
+	// The temporary files are renamed or removed.
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("ReadDir got err %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files in state directory, want 1", len(files))
+	}
+}
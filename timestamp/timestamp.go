@@ -0,0 +1,295 @@
+// Package timestamp finds lines in log files by the timestamps at their
+// start.
+package timestamp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Detect when no line has a timestamp.
+var ErrNotFound = errors.New("no timestamps found")
+
+// Format is a format of timestamps at the start of lines.
+type Format struct {
+	// Name describes the format.
+	Name string
+
+	// parse returns the timestamp at the start of line, if it has one.
+	parse func(line []byte) (time.Time, bool)
+}
+
+// Parse returns the timestamp at the start of line, if it has one.
+func (f *Format) Parse(line []byte) (time.Time, bool) {
+	return f.parse(line)
+}
+
+// String returns the name of f.
+func (f *Format) String() string {
+	return f.Name
+}
+
+// parsePrefix parses the first n bytes of line, and any fractional seconds
+// immediately after them, with layout.
+func parsePrefix(line []byte, layout string, n int) (time.Time, bool) {
+	if len(line) < n {
+		return time.Time{}, false
+	}
+
+	// time.Parse accepts fractional seconds after the seconds, even if
+	// the layout doesn't include them.
+	if n+1 < len(line) && line[n] == '.' && isDigit(line[n+1]) {
+		n++
+		for n < len(line) && isDigit(line[n]) {
+			n++
+		}
+	}
+
+	t, err := time.Parse(layout, string(line[:n]))
+	return t, err == nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// RFC3339 timestamps look like 2006-01-02T15:04:05Z07:00, optionally with
+// fractional seconds.
+var RFC3339 = &Format{
+	Name: "RFC 3339",
+	parse: func(line []byte) (time.Time, bool) {
+		// The timestamp runs up to the first space.
+		end := bytes.IndexAny(line, " \t\r\n")
+		if end < 0 {
+			end = len(line)
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, string(line[:end]))
+		return t, err == nil
+	},
+}
+
+// Syslog timestamps look like Jan  2 15:04:05.  They have no year, so
+// timestamps from different years can't be told apart.
+var Syslog = &Format{
+	Name: "syslog",
+	parse: func(line []byte) (time.Time, bool) {
+		return parsePrefix(line, time.Stamp, len(time.Stamp))
+	},
+}
+
+// GoLog timestamps are those written by the Go log package by default, like
+// 2006/01/02 15:04:05, optionally with microseconds.
+var GoLog = &Format{
+	Name: "Go log",
+	parse: func(line []byte) (time.Time, bool) {
+		const layout = "2006/01/02 15:04:05"
+		return parsePrefix(line, layout, len(layout))
+	},
+}
+
+// Formats are the formats Detect looks for.
+var Formats = []*Format{RFC3339, Syslog, GoLog}
+
+// maxDetectLines is the maximum number of lines Detect looks at.
+const maxDetectLines = 1000
+
+// readSize is the size of reads from files.  It is plenty for a timestamp at
+// the start of a line.
+const readSize = 4096
+
+// skipLine discards the rest of the line being read from br, returning the
+// number of bytes discarded.
+func skipLine(br *bufio.Reader) (int64, error) {
+	var n int64
+	for {
+		s, err := br.ReadSlice('\n')
+		n += int64(len(s))
+		if err != bufio.ErrBufferFull {
+			return n, err
+		}
+	}
+}
+
+// lines calls fn with the offset and beginning of each line in r, of size
+// bytes, that starts at or after offset off and before limit, until fn
+// returns false.  fn must not retain the beginning of the line.
+func lines(r io.ReaderAt, off, limit, size int64, fn func(start int64, line []byte) bool) error {
+	if off >= size {
+		return nil
+	}
+
+	// Start at the byte before off, to find out whether a line starts at
+	// off.
+	pos := off
+	if off > 0 {
+		pos--
+	}
+	br := bufio.NewReaderSize(io.NewSectionReader(r, pos, size-pos), readSize)
+
+	if off > 0 {
+		n, err := skipLine(br)
+		pos += n
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for pos < limit {
+		start := pos
+		line, err := br.ReadSlice('\n')
+		pos += int64(len(line))
+		if len(line) > 0 && !fn(start, line) {
+			return nil
+		}
+
+		// Only the beginning of a long line is needed.
+		if err == bufio.ErrBufferFull {
+			var n int64
+			n, err = skipLine(br)
+			pos += n
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Detect returns the format of the first timestamp at the start of a line at
+// or after offset off in r, of size bytes, and that timestamp.  If there are
+// none in the first lines it looks at, it returns ErrNotFound.
+func Detect(r io.ReaderAt, off, size int64) (*Format, time.Time, error) {
+	var format *Format
+	var t time.Time
+	n := 0
+	err := lines(r, off, size, size, func(_ int64, line []byte) bool {
+		for _, f := range Formats {
+			var ok bool
+			if t, ok = f.Parse(line); ok {
+				format = f
+				return false
+			}
+		}
+
+		n++
+		return n < maxDetectLines
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if format == nil {
+		return nil, time.Time{}, ErrNotFound
+	}
+	return format, t, nil
+}
+
+// next returns the offset and timestamp of the first line in r, of size
+// bytes, with a timestamp in format f, that starts at or after off and
+// before limit.
+func next(r io.ReaderAt, off, limit, size int64, f *Format) (start int64, t time.Time, ok bool, err error) {
+	err = lines(r, off, limit, size, func(s int64, line []byte) bool {
+		if t, ok = f.Parse(line); ok {
+			start = s
+			return false
+		}
+		return true
+	})
+	return start, t, ok, err
+}
+
+// Search returns the offset of the first line in r, of size bytes, with a
+// timestamp in format f at or after t, or size if there is none.  The lines
+// must be in order by time.  Lines without a timestamp, like stack traces,
+// are skipped.
+//
+// Search bisects the file by offset, so it reads only a few lines, and
+// doesn't need to know where the lines are in advance.
+func Search(r io.ReaderAt, size int64, f *Format, t time.Time) (int64, error) {
+	// The first line with a timestamp at or after t starts at or after
+	// lo.  Starting at hi, the first line with a timestamp is the one
+	// starting at hiStart, which is at or after t (or there is none).
+	lo, hi := int64(0), size
+	hiStart := size
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		// Lines between hi and hiStart have no timestamp, so
+		// there's no need to look at them again.
+		start, ts, ok, err := next(r, mid, hiStart, size, f)
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case !ok:
+			// The first line with a timestamp after mid is still
+			// the one at hiStart.
+			hi = mid
+		case !ts.Before(t):
+			hi, hiStart = mid, start
+		default:
+			// Every line starting before start is before t.
+			lo = start + 1
+		}
+	}
+
+	return hiStart, nil
+}
+
+// targetLayout is a layout of times accepted by ParseTarget.
+type targetLayout struct {
+	layout string
+
+	// date, year, and zone are true if the layout includes the date,
+	// the year, and the time zone.
+	date, year, zone bool
+}
+
+var targetLayouts = []targetLayout{
+	{layout: time.RFC3339Nano, date: true, year: true, zone: true},
+	{layout: "2006-01-02T15:04:05", date: true, year: true},
+	{layout: "2006-01-02 15:04:05", date: true, year: true},
+	{layout: "2006/01/02 15:04:05", date: true, year: true},
+	{layout: time.Stamp, date: true},
+	{layout: "Jan 2 15:04:05", date: true},
+	{layout: "15:04:05"},
+	{layout: "15:04"},
+}
+
+// ParseTarget parses a time to search for.  It may be a timestamp in any of
+// the formats, or just a time of day.  Any date, year, or time zone it
+// doesn't include are taken from ref.
+func ParseTarget(s string, ref time.Time) (time.Time, error) {
+	for _, l := range targetLayouts {
+		t, err := time.Parse(l.layout, s)
+		if err != nil {
+			continue
+		}
+
+		year, month, day := t.Date()
+		if !l.date {
+			year, month, day = ref.Date()
+		} else if !l.year {
+			year = ref.Year()
+		}
+		loc := ref.Location()
+		if l.zone {
+			loc = t.Location()
+		}
+
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
@@ -0,0 +1,163 @@
+package timestamp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		f    *Format
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{f: RFC3339, line: "2020-03-04T05:06:07Z hello\n", want: time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC), ok: true},
+		{f: RFC3339, line: "2020-03-04T05:06:07.5Z", want: time.Date(2020, 3, 4, 5, 6, 7, 5e8, time.UTC), ok: true},
+		{f: RFC3339, line: "2020-03-04T05:06:07+02:00 x", want: time.Date(2020, 3, 4, 3, 6, 7, 0, time.UTC), ok: true},
+		{f: RFC3339, line: "2020-03-04 05:06:07 x", ok: false},
+		{f: Syslog, line: "Mar  4 05:06:07 host sshd[1]: x", want: time.Date(0, 3, 4, 5, 6, 7, 0, time.UTC), ok: true},
+		{f: Syslog, line: "Mar 14 05:06:07 host", want: time.Date(0, 3, 14, 5, 6, 7, 0, time.UTC), ok: true},
+		{f: Syslog, line: "Mar", ok: false},
+		{f: GoLog, line: "2020/03/04 05:06:07 x", want: time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC), ok: true},
+		{f: GoLog, line: "2020/03/04 05:06:07.000123 x", want: time.Date(2020, 3, 4, 5, 6, 7, 123000, time.UTC), ok: true},
+		{f: GoLog, line: "\tat main.go:12", ok: false},
+	}
+
+	for _, c := range cases {
+		got, ok := c.f.Parse([]byte(c.line))
+		if ok != c.ok || !got.Equal(c.want) {
+			t.Errorf("%v Parse(%q) got %v, %v want %v, %v", c.f, c.line, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		data string
+		off  int64
+		want *Format
+		err  error
+	}{
+		{data: "2020-03-04T05:06:07Z a\n", want: RFC3339},
+		{data: "panic: x\n\tat y\nMar  4 05:06:07 a\n", want: Syslog},
+		{data: "2020/03/04 05:06:07 a\n", want: GoLog},
+		// Starting in the middle of the first line.
+		{data: "2020-03-04T05:06:07Z a\n2020/03/04 05:06:07 b\n", off: 1, want: GoLog},
+		{data: "no\ntimestamps\n", err: ErrNotFound},
+		{data: "", err: ErrNotFound},
+	}
+
+	for _, c := range cases {
+		r := strings.NewReader(c.data)
+		got, _, err := Detect(r, c.off, r.Size())
+		if got != c.want || err != c.err {
+			t.Errorf("Detect(%q, %d) got %v, %v want %v, %v", c.data, c.off, got, err, c.want, c.err)
+		}
+	}
+}
+
+// countingReader counts reads from a ReaderAt.
+type countingReader struct {
+	r     *bytes.Reader
+	reads int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func TestSearch(t *testing.T) {
+	// One line a second, with a stack trace after every tenth.
+	start := time.Date(2020, 3, 4, 5, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	offsets := make(map[int]int64)
+	const n = 100000
+	for i := 0; i < n; i++ {
+		offsets[i] = int64(buf.Len())
+		fmt.Fprintf(&buf, "%s line %d\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+		if i%10 == 0 {
+			buf.WriteString("panic: oops\n\tat main.go:12\n\tat main.go:34\n")
+		}
+	}
+	size := int64(buf.Len())
+
+	cases := []struct {
+		t    time.Time
+		want int64
+	}{
+		{t: start.Add(-time.Hour), want: 0},
+		{t: start, want: offsets[0]},
+		{t: start.Add(time.Second), want: offsets[1]},
+		{t: start.Add(1500 * time.Millisecond), want: offsets[2]},
+		{t: start.Add(11 * time.Second), want: offsets[11]},
+		{t: start.Add(12345 * time.Second), want: offsets[12345]},
+		{t: start.Add((n - 1) * time.Second), want: offsets[n-1]},
+		{t: start.Add(n * time.Second), want: size},
+	}
+
+	for _, c := range cases {
+		r := &countingReader{r: bytes.NewReader(buf.Bytes())}
+		got, err := Search(r, size, RFC3339, c.t)
+		if err != nil {
+			t.Errorf("Search(%v) got err %v", c.t, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Search(%v) got %d want %d", c.t, got, c.want)
+		}
+
+		// A few reads for each step of the bisection.
+		if max := 100; r.reads > max {
+			t.Errorf("Search(%v) read %d times, want at most %d", c.t, r.reads, max)
+		}
+	}
+}
+
+func TestSearchNoTimestamps(t *testing.T) {
+	r := strings.NewReader("a\nb\nc\n")
+	got, err := Search(r, r.Size(), Syslog, time.Time{})
+	if err != nil {
+		t.Fatalf("Search got err %v", err)
+	}
+	if got != r.Size() {
+		t.Errorf("Search got %d want %d", got, r.Size())
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	east := time.FixedZone("east", 2*60*60)
+	ref := time.Date(2020, 3, 4, 5, 6, 7, 0, east)
+
+	cases := []struct {
+		s    string
+		want time.Time
+		err  bool
+	}{
+		{s: "14:32:05", want: time.Date(2020, 3, 4, 14, 32, 5, 0, east)},
+		{s: "14:32", want: time.Date(2020, 3, 4, 14, 32, 0, 0, east)},
+		{s: "14:32:05.25", want: time.Date(2020, 3, 4, 14, 32, 5, 25e7, east)},
+		{s: "2021-01-02 14:32:05", want: time.Date(2021, 1, 2, 14, 32, 5, 0, east)},
+		{s: "2021/01/02 14:32:05", want: time.Date(2021, 1, 2, 14, 32, 5, 0, east)},
+		{s: "2021-01-02T14:32:05Z", want: time.Date(2021, 1, 2, 14, 32, 5, 0, time.UTC)},
+		{s: "Jan  2 14:32:05", want: time.Date(2020, 1, 2, 14, 32, 5, 0, east)},
+		{s: "Jan 2 14:32:05", want: time.Date(2020, 1, 2, 14, 32, 5, 0, east)},
+		{s: "2:32pm", err: true},
+		{s: "", err: true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseTarget(c.s, ref)
+		if (err != nil) != c.err {
+			t.Errorf("ParseTarget(%q) got err %v want err %v", c.s, err, c.err)
+			continue
+		}
+		if !c.err && !got.Equal(c.want) {
+			t.Errorf("ParseTarget(%q) got %v want %v", c.s, got, c.want)
+		}
+	}
+}